@@ -2,6 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/diwise/service-chassis/pkg/infrastructure/buildinfo"
 	"github.com/diwise/service-chassis/pkg/infrastructure/env"
@@ -10,6 +15,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
 
 	"github.com/diwise/integration-cip-gbg-watermeter/internal/pkg/application"
 	"github.com/diwise/integration-cip-gbg-watermeter/internal/pkg/presentation/api"
@@ -17,18 +23,64 @@ import (
 
 const serviceName string = "integration-cip-gbg-watermeter"
 
+// shutdownTimeout is how long requests in progress are given to complete when the service is stopped
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	serviceVersion := buildinfo.SourceVersion()
 	_, logger, cleanup := o11y.Init(context.Background(), serviceName, serviceVersion)
-	defer cleanup()
 
-	port := env.GetVariableOrDefault(logger, "SERVICE_PORT", "8080")
+	err := run(logger)
+	cleanup()
 
-	storage, err := application.NewStorage()
 	if err != nil {
 		logger.Fatal().Msg(err.Error())
 	}
-	app := application.New(storage)
+}
+
+// run starts the service and blocks until it is stopped by a signal or the http server fails. Everything that
+// was started is closed before it returns, the http server first so that no notifications are accepted after
+// the application has been closed.
+func run(logger zerolog.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	port := env.GetVariableOrDefault(logger, "SERVICE_PORT", "8080")
+
+	cfg, err := application.LoadStorageConfig(logger)
+	if err != nil {
+		return err
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		return runMigrate(ctx, cfg, os.Args[2:])
+	}
+
+	appConfig, err := application.LoadConfig(logger)
+	if err != nil {
+		return err
+	}
+
+	apiConfig, err := api.LoadConfig(logger)
+	if err != nil {
+		return err
+	}
+
+	// readings are stored in the unit that they are converted to when received
+	cfg.ConsumptionUnit = appConfig.Validation.ConsumptionUnit
+
+	storage, err := application.NewStorage(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	app, err := application.New(storage, appConfig)
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
 	router := chi.NewRouter()
 
 	router.Use(middleware.RequestID)
@@ -36,9 +88,30 @@ func main() {
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
 
-	api := api.New(logger, router, app)
+	server := api.New(logger, router, app, apiConfig)
 
 	metrics.AddHandlers(router)
 
-	api.Start(port)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Start(port)
+	}()
+
+	select {
+	case err = <-stopped:
+		return fmt.Errorf("http server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info().Msg("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		return fmt.Errorf("failed to shut down the http server: %w", err)
+	}
+
+	return nil
 }
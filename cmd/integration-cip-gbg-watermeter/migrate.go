@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/diwise/integration-cip-gbg-watermeter/internal/pkg/application"
+)
+
+// runMigrate handles the migrate subcommand, i.e. `migrate [up|status]`
+func runMigrate(ctx context.Context, cfg application.StorageConfig, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		return application.Migrate(ctx, cfg)
+	case "status":
+		migrations, err := application.MigrationStatus(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, m := range migrations {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02T15:04:05Z07:00")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, applied)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up or status", cmd)
+	}
+}
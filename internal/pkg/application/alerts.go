@@ -0,0 +1,460 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type AlarmState string
+
+const (
+	AlarmRaised  AlarmState = "raised"
+	AlarmCleared AlarmState = "cleared"
+)
+
+// alarms are the flag properties of a WaterConsumptionObserved that alert rules can refer to
+var alarms = map[string]func(WaterConsumptionObserved) *FlagProperty{
+	"alarmStopsLeaks":         func(wco WaterConsumptionObserved) *FlagProperty { return wco.AlarmStopsLeaks },
+	"alarmTamper":             func(wco WaterConsumptionObserved) *FlagProperty { return wco.AlarmTamper },
+	"alarmMetrology":          func(wco WaterConsumptionObserved) *FlagProperty { return wco.AlarmMetrology },
+	"alarmWaterQuality":       func(wco WaterConsumptionObserved) *FlagProperty { return wco.AlarmWaterQuality },
+	"alarmSystem":             func(wco WaterConsumptionObserved) *FlagProperty { return wco.AlarmSystem },
+	"alarmInProgress":         func(wco WaterConsumptionObserved) *FlagProperty { return wco.AlarmInProgress },
+	"moduleTampered":          func(wco WaterConsumptionObserved) *FlagProperty { return wco.ModuleTampered },
+	"acquisitionStageFailure": func(wco WaterConsumptionObserved) *FlagProperty { return wco.AcquisitionStageFailure },
+}
+
+// AlertRule sends a webhook when one of its alarms is raised or cleared on a meter. Payload is a text/template
+// that is executed with an Alert and must produce JSON; the Alert itself is sent if it is empty. Alerts for the
+// same meter, alarm and state are not sent again within the DedupWindow.
+type AlertRule struct {
+	Name        string            `json:"name"`
+	Alarms      []string          `json:"alarms"`
+	On          []AlarmState      `json:"on"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers"`
+	Payload     string            `json:"payload"`
+	DedupWindow string            `json:"dedupWindow"`
+
+	dedup    time.Duration
+	template *template.Template
+}
+
+// Alert is a change of an alarm on a meter
+type Alert struct {
+	Rule             string     `json:"rule"`
+	EntityId         string     `json:"entityId"`
+	Alarm            string     `json:"alarm"`
+	State            AlarmState `json:"state"`
+	ObservedAt       string     `json:"observedAt"`
+	ObservedBy       string     `json:"observedBy,omitempty"`
+	WaterConsumption float64    `json:"waterConsumption"`
+	UnitCode         string     `json:"unitCode,omitempty"`
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func (r *AlertRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("a name is required")
+	}
+
+	if len(r.Alarms) == 0 {
+		return fmt.Errorf("at least one alarm is required")
+	}
+
+	for _, alarm := range r.Alarms {
+		if _, ok := alarms[alarm]; !ok {
+			return fmt.Errorf("unknown alarm %s", alarm)
+		}
+	}
+
+	if len(r.On) == 0 {
+		r.On = []AlarmState{AlarmRaised, AlarmCleared}
+	}
+
+	for _, s := range r.On {
+		if s != AlarmRaised && s != AlarmCleared {
+			return fmt.Errorf("invalid state %s, expected %s or %s", s, AlarmRaised, AlarmCleared)
+		}
+	}
+
+	if !strings.HasPrefix(r.URL, "http://") && !strings.HasPrefix(r.URL, "https://") {
+		return fmt.Errorf("invalid url %q", r.URL)
+	}
+
+	if r.DedupWindow != "" {
+		var err error
+		r.dedup, err = time.ParseDuration(r.DedupWindow)
+		if err != nil || r.dedup < 0 {
+			return fmt.Errorf("invalid dedupWindow, expected a duration")
+		}
+	}
+
+	if r.Payload != "" {
+		var err error
+		r.template, err = template.New(r.Name).Funcs(templateFuncs).Parse(r.Payload)
+		if err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *AlertRule) matches(alarm string, state AlarmState) bool {
+	return slices.Contains(r.Alarms, alarm) && slices.Contains(r.On, state)
+}
+
+func (r *AlertRule) payload(a Alert) ([]byte, error) {
+	if r.template == nil {
+		return json.Marshal(a)
+	}
+
+	buf := &bytes.Buffer{}
+	err := r.template.Execute(buf, a)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("payload is not valid json: %s", buf.String())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// loadAlertRules reads a JSON array of alert rules from a file
+func loadAlertRules(path string) ([]AlertRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read alert rules: %w", err)
+	}
+
+	rules := []AlertRule{}
+	err = json.Unmarshal(b, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse alert rules: %w", err)
+	}
+
+	for i := range rules {
+		err = rules[i].compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid alert rule %d: %w", i, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// AlarmKey identifies an alarm of a meter
+type AlarmKey struct {
+	Id    string
+	Alarm string
+}
+
+// AlarmValue is the state of an alarm as of the latest reading that it was observed in
+type AlarmValue struct {
+	Raised     bool
+	ObservedAt time.Time
+}
+
+type delivery struct {
+	ctx   context.Context
+	rule  *AlertRule
+	alert Alert
+	body  []byte
+}
+
+// alerter keeps track of the alarms of every meter and sends webhooks when they change. An alarm that has not
+// been seen before is considered to have been cleared, and readings that are older than the latest one seen
+// for a meter are ignored. The state is stored, so that it is shared by every instance of the service and is
+// kept when the service is restarted, while sent alerts are only de-duplicated within each instance.
+type alerter struct {
+	cfg     AlertingConfig
+	client  *http.Client
+	storage Storage
+
+	mu     sync.Mutex
+	closed bool
+	sent   map[string]time.Time
+
+	deliveries chan delivery
+	stop       chan struct{}
+	wg         sync.WaitGroup
+
+	alerts metric.Int64Counter
+}
+
+func newAlerter(cfg AlertingConfig, s Storage) *alerter {
+	a := &alerter{
+		cfg:        cfg,
+		client:     &http.Client{Timeout: cfg.Timeout},
+		storage:    s,
+		sent:       map[string]time.Time{},
+		deliveries: make(chan delivery, cfg.QueueSize),
+		stop:       make(chan struct{}),
+	}
+
+	a.alerts, _ = meter.Int64Counter("alerts", metric.WithDescription("number of alerts by rule and outcome"))
+
+	a.wg.Add(1)
+	go a.deliver()
+
+	return a
+}
+
+// observe compares the alarms of the stored water consumption observations with the previous state of each
+// meter and queues a webhook for every rule that matches a change. Duplicates are not compared. The state is
+// updated before any webhook is queued, and nothing is sent if it can not be updated.
+func (a *alerter) observe(ctx context.Context, obs []Observation, duplicates []bool) {
+	log := logging.GetFromContext(ctx)
+
+	readings := []WaterConsumptionObserved{}
+	ids := []string{}
+
+	for i, o := range obs {
+		wco, ok := o.(WaterConsumptionObserved)
+		if !ok || (i < len(duplicates) && duplicates[i]) {
+			continue
+		}
+
+		readings = append(readings, wco)
+		if !slices.Contains(ids, wco.Id) {
+			ids = append(ids, wco.Id)
+		}
+	}
+
+	if len(readings) == 0 {
+		return
+	}
+
+	var changes []Alert
+
+	err := a.storage.UpdateAlarms(ctx, ids, func(state map[AlarmKey]AlarmValue) {
+		changes = []Alert{}
+		for _, wco := range readings {
+			changes = append(changes, alarmChanges(state, wco)...)
+		}
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to update the alarms of %d meters, no alerts are sent", len(ids))
+		return
+	}
+
+	for _, alert := range changes {
+		a.fire(ctx, alert)
+	}
+}
+
+// alarmChanges updates the state of every alarm in the observation and returns the ones that changed
+func alarmChanges(state map[AlarmKey]AlarmValue, wco WaterConsumptionObserved) []Alert {
+	observedAt, _ := time.Parse(time.RFC3339, wco.WaterConsumption.ObservedAt)
+
+	changes := []Alert{}
+
+	for name, property := range alarms {
+		p := property(wco)
+		if p == nil {
+			continue
+		}
+
+		key := AlarmKey{Id: wco.Id, Alarm: name}
+		previous := state[key]
+
+		if !observedAt.IsZero() && observedAt.Before(previous.ObservedAt) {
+			continue
+		}
+
+		state[key] = AlarmValue{Raised: p.Value, ObservedAt: observedAt}
+
+		if p.Value == previous.Raised {
+			continue
+		}
+
+		change := AlarmCleared
+		if p.Value {
+			change = AlarmRaised
+		}
+
+		changes = append(changes, Alert{
+			EntityId:         wco.Id,
+			Alarm:            name,
+			State:            change,
+			ObservedAt:       wco.WaterConsumption.ObservedAt,
+			ObservedBy:       wco.WaterConsumption.ObservedBy.Object,
+			WaterConsumption: wco.WaterConsumption.Value,
+			UnitCode:         wco.WaterConsumption.UnitCode,
+		})
+	}
+
+	return changes
+}
+
+// suppressed reports whether the rule has already sent the same alert within its de-duplication window
+func (a *alerter) suppressed(rule *AlertRule, alert Alert, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := strings.Join([]string{rule.Name, alert.EntityId, alert.Alarm, string(alert.State)}, "|")
+
+	if last, ok := a.sent[key]; ok && now.Sub(last) < rule.dedup {
+		return true
+	}
+
+	a.sent[key] = now
+	return false
+}
+
+func (a *alerter) fire(ctx context.Context, alert Alert) {
+	log := logging.GetFromContext(ctx)
+
+	for i := range a.cfg.Rules {
+		rule := &a.cfg.Rules[i]
+		if !rule.matches(alert.Alarm, alert.State) {
+			continue
+		}
+
+		alert.Rule = rule.Name
+
+		if a.suppressed(rule, alert, time.Now()) {
+			a.record(ctx, rule, "suppressed")
+			continue
+		}
+
+		body, err := rule.payload(alert)
+		if err != nil {
+			log.Error().Err(err).Msgf("unable to create payload for alert rule %s", rule.Name)
+			a.record(ctx, rule, "invalid")
+			continue
+		}
+
+		if !a.enqueue(delivery{ctx: context.WithoutCancel(ctx), rule: rule, alert: alert, body: body}) {
+			log.Warn().Msgf("alert queue is full or closed, %s %s on %s is dropped", alert.Alarm, alert.State, alert.EntityId)
+			a.record(ctx, rule, "dropped")
+		}
+	}
+}
+
+func (a *alerter) enqueue(d delivery) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return false
+	}
+
+	select {
+	case a.deliveries <- d:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *alerter) record(ctx context.Context, rule *AlertRule, outcome string) {
+	a.alerts.Add(ctx, 1, metric.WithAttributes(attribute.String("rule", rule.Name), attribute.String("outcome", outcome)))
+}
+
+func (a *alerter) deliver() {
+	defer a.wg.Done()
+
+	for d := range a.deliveries {
+		log := logging.GetFromContext(d.ctx)
+
+		err := a.send(d)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to send %s %s on %s to %s", d.alert.Alarm, d.alert.State, d.alert.EntityId, d.rule.Name)
+			a.record(d.ctx, d.rule, "failed")
+			continue
+		}
+
+		log.Info().Msgf("%s %s on %s sent to %s", d.alert.Alarm, d.alert.State, d.alert.EntityId, d.rule.Name)
+		a.record(d.ctx, d.rule, "sent")
+	}
+}
+
+// errPermanent wraps responses that will not succeed if the request is sent again
+var errPermanent = errors.New("permanent failure")
+
+// send posts the payload until it is accepted, the attempts are exhausted or the alerter is closed. Client errors,
+// except for 408 and 429, are not retried.
+func (a *alerter) send(d delivery) error {
+	var err error
+
+	for attempt := 0; attempt < a.cfg.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-a.stop:
+				return fmt.Errorf("alerter closed before delivery: %w", err)
+			case <-time.After(backoff(attempt-1, a.cfg.InitialBackoff, a.cfg.MaxBackoff)):
+			}
+		}
+
+		err = a.post(d)
+		if err == nil || errors.Is(err, errPermanent) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (a *alerter) post(d delivery) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, d.rule.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return fmt.Errorf("%w: %s", errPermanent, err.Error())
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range d.rule.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("%w: webhook responded with %d", errPermanent, resp.StatusCode)
+	}
+}
+
+// close sends the alerts that are already queued, without retrying failed attempts, and stops the delivery
+func (a *alerter) close() {
+	a.mu.Lock()
+	a.closed = true
+	close(a.stop)
+	close(a.deliveries)
+	a.mu.Unlock()
+
+	a.wg.Wait()
+}
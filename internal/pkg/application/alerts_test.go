@@ -0,0 +1,237 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatAlarmTransitionsAreSent(t *testing.T) {
+	is, a, w := setupAlertTest(t, `[{"name":"leaks","alarms":["alarmStopsLeaks"],"url":"%s"}]`)
+
+	a.observe(context.Background(), []Observation{
+		meterReading("2023-10-01T00:00:00Z", false),
+		meterReading("2023-10-01T01:00:00Z", true),
+		meterReading("2023-10-01T02:00:00Z", true),
+		meterReading("2023-10-01T03:00:00Z", false),
+	}, nil)
+	a.close()
+
+	is.Equal(len(w.get()), 2)
+
+	raised := Alert{}
+	is.NoErr(json.Unmarshal(w.get()[0], &raised))
+	is.Equal(raised.Rule, "leaks")
+	is.Equal(raised.EntityId, "urn:ngsi-ld:WaterConsumptionObserved:01")
+	is.Equal(raised.State, AlarmRaised)
+	is.Equal(raised.ObservedAt, "2023-10-01T01:00:00Z")
+
+	cleared := Alert{}
+	is.NoErr(json.Unmarshal(w.get()[1], &cleared))
+	is.Equal(cleared.State, AlarmCleared)
+}
+
+func TestThatPayloadsAreTemplated(t *testing.T) {
+	is, a, w := setupAlertTest(t, `[{"name":"leaks","alarms":["alarmStopsLeaks"],"on":["raised"],"url":"%s","payload":"{\"text\": {{json (printf \"%%s on %%s\" .Alarm .EntityId)}}}"}]`)
+
+	a.observe(context.Background(), []Observation{meterReading("2023-10-01T01:00:00Z", true), meterReading("2023-10-01T02:00:00Z", false)}, nil)
+	a.close()
+
+	is.Equal(len(w.get()), 1)
+	is.Equal(string(w.get()[0]), `{"text": "alarmStopsLeaks on urn:ngsi-ld:WaterConsumptionObserved:01"}`)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	is.Equal(w.contentType, "application/json")
+}
+
+func TestThatRepeatedAlertsAreSuppressedWithinTheWindow(t *testing.T) {
+	is, a, w := setupAlertTest(t, `[{"name":"leaks","alarms":["alarmStopsLeaks"],"on":["raised"],"url":"%s","dedupWindow":"1h"}]`)
+
+	a.observe(context.Background(), []Observation{
+		meterReading("2023-10-01T01:00:00Z", true),
+		meterReading("2023-10-01T02:00:00Z", false),
+		meterReading("2023-10-01T03:00:00Z", true),
+	}, nil)
+	a.close()
+
+	is.Equal(len(w.get()), 1)
+}
+
+func TestThatOlderAndDuplicateReadingsAreIgnored(t *testing.T) {
+	is, a, w := setupAlertTest(t, `[{"name":"leaks","alarms":["alarmStopsLeaks"],"url":"%s"}]`)
+
+	a.observe(context.Background(), []Observation{
+		meterReading("2023-10-01T02:00:00Z", true),
+		meterReading("2023-10-01T01:00:00Z", false),
+		meterReading("2023-10-01T03:00:00Z", false),
+	}, []bool{false, false, true})
+	a.close()
+
+	is.Equal(len(w.get()), 1)
+}
+
+func TestThatAlarmsAreNotAlertedAgainAfterARestart(t *testing.T) {
+	is, a, w := setupAlertTest(t, `[{"name":"leaks","alarms":["alarmStopsLeaks"],"url":"%s"}]`)
+
+	state := map[AlarmKey]AlarmValue{}
+	a.storage = alarmStorage(state)
+
+	a.observe(context.Background(), []Observation{meterReading("2023-10-01T01:00:00Z", true)}, nil)
+	a.close()
+
+	is.Equal(len(w.get()), 1)
+	is.Equal(state[AlarmKey{Id: "urn:ngsi-ld:WaterConsumptionObserved:01", Alarm: "alarmStopsLeaks"}].Raised, true)
+
+	// another instance, or the same one after a restart, shares the stored state
+	b := newAlerter(a.cfg, alarmStorage(state))
+
+	b.observe(context.Background(), []Observation{meterReading("2023-10-01T02:00:00Z", true)}, nil)
+	b.observe(context.Background(), []Observation{meterReading("2023-10-01T03:00:00Z", false)}, nil)
+	b.close()
+
+	is.Equal(len(w.get()), 2) // only the alarm being cleared
+}
+
+func TestThatNoAlertsAreSentIfTheStateCanNotBeUpdated(t *testing.T) {
+	is, a, w := setupAlertTest(t, `[{"name":"leaks","alarms":["alarmStopsLeaks"],"url":"%s"}]`)
+
+	a.storage = &StorageMock{
+		UpdateAlarmsFunc: func(ctx context.Context, ids []string, update func(map[AlarmKey]AlarmValue)) error {
+			return errors.New("database is unavailable")
+		},
+	}
+
+	a.observe(context.Background(), []Observation{meterReading("2023-10-01T01:00:00Z", true)}, nil)
+	a.close()
+
+	is.Equal(len(w.get()), 0)
+}
+
+func TestThatFailedDeliveriesAreRetried(t *testing.T) {
+	is, a, w := setupAlertTest(t, `[{"name":"leaks","alarms":["alarmStopsLeaks"],"url":"%s"}]`)
+	w.failures = 2
+
+	a.observe(context.Background(), []Observation{meterReading("2023-10-01T01:00:00Z", true)}, nil)
+
+	is.True(waitFor(func() bool { return len(w.get()) == 1 }))
+	a.close()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	is.Equal(w.attempts, 3)
+}
+
+func TestThatInvalidAlertRulesAreRejected(t *testing.T) {
+	is := is.New(t)
+
+	for _, rules := range []string{
+		`[{"name":"leaks","alarms":["alarmUnknown"],"url":"http://localhost"}]`,
+		`[{"name":"leaks","alarms":["alarmStopsLeaks"],"on":["changed"],"url":"http://localhost"}]`,
+		`[{"name":"leaks","alarms":["alarmStopsLeaks"],"url":"localhost"}]`,
+		`[{"name":"leaks","alarms":["alarmStopsLeaks"],"url":"http://localhost","dedupWindow":"1 hour"}]`,
+		`[{"name":"leaks","alarms":["alarmStopsLeaks"],"url":"http://localhost","payload":"{{.Alarm"}]`,
+	} {
+		_, err := loadAlertRules(writeRules(t, rules))
+		is.True(err != nil)
+	}
+}
+
+type webhook struct {
+	mu          sync.Mutex
+	failures    int
+	attempts    int
+	contentType string
+	received    [][]byte
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.attempts++
+	if w.attempts <= w.failures {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	w.received = append(w.received, body)
+	w.contentType = r.Header.Get("Content-Type")
+}
+
+func (w *webhook) get() [][]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.received
+}
+
+func waitFor(condition func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func meterReading(observedAt string, leak bool) WaterConsumptionObserved {
+	wco := WaterConsumptionObserved{Entity: Entity{Id: "urn:ngsi-ld:WaterConsumptionObserved:01", Type: "WaterConsumptionObserved"}}
+	wco.WaterConsumption.ObservedAt = observedAt
+	wco.AlarmStopsLeaks = &FlagProperty{Value: leak, ObservedAt: observedAt}
+	return wco
+}
+
+func writeRules(t *testing.T, rules string) string {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(path, []byte(rules), 0o600)
+	return path
+}
+
+func setupAlertTest(t *testing.T, rules string) (*is.I, *alerter, *webhook) {
+	is := is.New(t)
+
+	w := &webhook{}
+	server := httptest.NewServer(w)
+	t.Cleanup(server.Close)
+
+	r, err := loadAlertRules(writeRules(t, fmt.Sprintf(rules, server.URL)))
+	is.NoErr(err)
+
+	a := newAlerter(AlertingConfig{
+		Rules:          r,
+		QueueSize:      10,
+		Timeout:        time.Second,
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}, alarmStorage(map[AlarmKey]AlarmValue{}))
+
+	return is, a, w
+}
+
+// alarmStorage returns a storage that keeps the state of the alarms in state
+func alarmStorage(state map[AlarmKey]AlarmValue) *StorageMock {
+	mu := sync.Mutex{}
+
+	return &StorageMock{
+		UpdateAlarmsFunc: func(ctx context.Context, ids []string, update func(map[AlarmKey]AlarmValue)) error {
+			mu.Lock()
+			defer mu.Unlock()
+			update(state)
+			return nil
+		},
+	}
+}
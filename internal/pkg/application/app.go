@@ -3,91 +3,447 @@ package application
 import (
 	"context"
 	"encoding/json"
-	"strings"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("integration-cip-gbg-watermeter/application")
+var meter = otel.Meter("integration-cip-gbg-watermeter/application")
+
 //go:generate moq -rm -out app_mock.go . App
 
 type App interface {
-	NotificationReceived(ctx context.Context, n Notification) error
+	NotificationReceived(ctx context.Context, n Notification) (NotificationResult, error)
+	DeadLetters(ctx context.Context, offset, limit int) ([]DeadLetter, error)
+	DeadLetter(ctx context.Context, id int64) (DeadLetter, error)
+	ReprocessDeadLetter(ctx context.Context, id int64) (EntityResult, error)
+	DiscardDeadLetter(ctx context.Context, id int64) error
+	WaterMeters(ctx context.Context, offset, limit int) ([]WaterMeter, error)
+	WaterMeter(ctx context.Context, id string) (WaterMeter, error)
+	Series(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error
+	Features(ctx context.Context, q FeatureQuery) ([]Feature, error)
+	ExportFeatures(ctx context.Context, q FeatureQuery, fn func(Feature) error) error
+	Close()
 }
 
+var ErrInvalidEntity = errors.New("invalid entity")
+
 type app struct {
-	storage Storage
+	storage    Storage
+	handlers   *Registry
+	validation *validation
+	// queue is nil unless notifications are stored asynchronously
+	queue *queue
+	// spool is nil unless notifications that could not be stored should be kept on disk
+	spool *spool
+	// leaks is nil unless leak detection is enabled
+	leaks *leakDetector
+	// alerts is nil unless there are alert rules
+	alerts *alerter
+	done   chan struct{}
+	wg     sync.WaitGroup
 }
 
-func New(s Storage) App {
-	return &app{
-		storage: s,
+func New(s Storage, cfg Config) (App, error) {
+	a := &app{
+		storage:    s,
+		handlers:   defaultRegistry,
+		validation: newValidation(cfg.Validation),
+		done:       make(chan struct{}),
+	}
+
+	if cfg.Queue.Enabled && cfg.Spool.Dir == "" {
+		return nil, fmt.Errorf("the ingestion queue requires a spool")
+	}
+
+	if cfg.Spool.Dir != "" {
+		var err error
+		a.spool, err = newSpool(cfg.Spool)
+		if err != nil {
+			return nil, err
+		}
+
+		a.wg.Add(1)
+		go a.replaySpool(cfg.Spool.ReplayInterval)
 	}
+
+	if cfg.Queue.Enabled {
+		a.queue = newQueue(cfg.Queue, a.persist)
+	}
+
+	if cfg.LeakDetection.Enabled {
+		a.leaks = newLeakDetector(s, cfg.LeakDetection)
+
+		a.wg.Add(1)
+		go a.detectLeaks()
+	}
+
+	if len(cfg.Alerting.Rules) > 0 {
+		a.alerts = newAlerter(cfg.Alerting, s)
+	}
+
+	return a, nil
 }
 
-func (a *app) NotificationReceived(ctx context.Context, n Notification) error {
+// batch holds the observations in a notification that should be stored, along with a copy of the
+// notification containing the corresponding entities so that it can be spooled if needed. Entities
+// that are invalid are part of the batch as dead letters.
+type batch struct {
+	notification Notification
+	observations []Observation
+}
+
+// decode handles every entity in the notification and returns the outcome for each of them together with a
+// batch of everything that should be stored. The indices of the entities that end up in the batch are
+// returned in the same order as the observations.
+func (a *app) decode(ctx context.Context, n Notification) ([]EntityResult, batch, []int) {
+	entities := make([]EntityResult, 0, len(n.Entities))
+	indices := make([]int, 0, len(n.Entities))
+
+	b := batch{
+		notification: Notification{Entity: n.Entity, SubscriptionId: n.SubscriptionId, NotifiedAt: n.NotifiedAt},
+	}
+
+	for i, e := range n.Entities {
+		r, o := a.handleEntity(ctx, i, e)
+
+		if r.Status == EntityInvalid {
+			o = newDeadLetter(r, n.SubscriptionId, e)
+		}
+
+		if o != nil {
+			b.observations = append(b.observations, o)
+			b.notification.Entities = append(b.notification.Entities, e)
+			indices = append(indices, len(entities))
+		}
+
+		entities = append(entities, r)
+	}
+
+	return entities, b, indices
+}
+
+// NotificationReceived handles every entity in the notification and reports the outcome for each of them.
+// All supported and valid entities are stored in a single transaction, so either all of them are persisted
+// or all of them are reported as failed together with the returned error. If a queue is configured the
+// valid entities are reported as accepted and stored by a background worker instead. Invalid entities
+// are kept as dead letters.
+func (a *app) NotificationReceived(ctx context.Context, n Notification) (NotificationResult, error) {
 	log := logging.GetFromContext(ctx)
 
 	log.Debug().Msgf("notification received with %d entities", len(n.Entities))
 
-	for i, e := range n.Entities {
-		entity := Entity{}
-		err := json.Unmarshal(e, &entity)
+	entities, b, indices := a.decode(ctx, n)
+
+	var err error
+
+	if len(b.observations) > 0 {
+		var stored StoreResult
+		var spooled bool
+
+		if a.queue != nil {
+			err = a.queue.enqueue(ctx, b)
+		} else {
+			stored, spooled, err = a.persist(ctx, b)
+		}
+
+		for j, idx := range indices {
+			if dl, ok := b.observations[j].(DeadLetter); ok {
+				entities[idx].Status = EntityInvalid
+				entities[idx].Error = dl.Error
+				continue
+			}
+
+			switch {
+			case err != nil:
+				entities[idx].Status = EntityFailed
+				entities[idx].Error = err.Error()
+			case a.queue != nil:
+				entities[idx].Status = EntityAccepted
+			case spooled:
+				entities[idx].Status = EntitySpooled
+			case stored.Duplicates[j]:
+				entities[idx].Status = EntityDuplicate
+			default:
+				entities[idx].Status = EntityStored
+			}
+		}
+	}
+
+	result := NotificationResult{
+		Entities: make([]EntityResult, 0, len(entities)),
+	}
+
+	for _, r := range entities {
+		result.add(r)
+	}
+
+	log.Debug().Msgf("notification handled, %d stored, %d accepted, %d spooled, %d duplicates, %d unsupported, %d invalid, %d failed", result.Stored, result.Accepted, result.Spooled, result.Duplicates, result.Unsupported, result.Invalid, result.Failed)
+
+	return result, err
+}
+
+// persist stores a batch and, if a spool is configured, writes it to the spool when it could not be stored
+func (a *app) persist(ctx context.Context, b batch) (StoreResult, bool, error) {
+	log := logging.GetFromContext(ctx)
+
+	stored, err := a.store(ctx, b)
+	if err == nil || a.spool == nil {
+		return stored, false, err
+	}
+
+	spoolErr := a.spool.write(b.notification)
+	if spoolErr != nil {
+		log.Error().Err(spoolErr).Msg("failed to spool notification")
+		return stored, false, errors.Join(err, spoolErr)
+	}
+
+	log.Warn().Msgf("%d entities spooled until the database is available", len(b.observations))
+
+	return stored, true, nil
+}
+
+// store writes a batch to the database. If an observation is rejected because of its data, it is
+// replaced by a dead letter and the rest of the batch is stored again.
+func (a *app) store(ctx context.Context, b batch) (StoreResult, error) {
+	log := logging.GetFromContext(ctx)
+
+	for {
+		stored, err := a.storage.Store(ctx, b.observations)
+
+		rejected := &ObservationError{}
+		if errors.As(err, &rejected) && rejected.Permanent() {
+			if _, ok := b.observations[rejected.Index].(DeadLetter); !ok {
+				log.Warn().Err(err).Msg("observation rejected by the database, storing it as a dead letter")
+
+				entity := Entity{}
+				json.Unmarshal(b.notification.Entities[rejected.Index], &entity)
+
+				r := EntityResult{Id: entity.Id, Type: entity.Type, Error: err.Error()}
+				b.observations[rejected.Index] = newDeadLetter(r, b.notification.SubscriptionId, b.notification.Entities[rejected.Index])
+				continue
+			}
+		}
+
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to store %d observations", len(b.observations))
+			return stored, fmt.Errorf("failed to store %d observations: %w", len(b.observations), err)
+		}
+
+		log.Debug().Msgf("%d observations inserted, %d skipped", stored.Inserted, stored.Skipped)
+
+		if a.alerts != nil {
+			a.alerts.observe(ctx, b.observations, stored.Duplicates)
+		}
+
+		return stored, nil
+	}
+}
+
+// replaySpool periodically checks if the database is available again and, if so, stores spooled notifications
+func (a *app) replaySpool(interval time.Duration) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+
+		if a.spool.empty() {
+			continue
+		}
+
+		ctx := context.Background()
+		log := logging.GetFromContext(ctx)
+
+		err := a.storage.Ping(ctx)
 		if err != nil {
-			log.Error().Err(err).Msgf("unable to unmarshal entity [%d] in notification", i)
-			return err
+			log.Debug().Err(err).Msg("database is still unavailable, spool will not be replayed")
+			continue
 		}
 
-		switch strings.ToLower(entity.Type) {
-		case "waterconsumptionobserved":
-			return a.handleWaterConsumptionObserved(ctx, e)
-		case "indoorenvironmentobserved":
-			return a.handleIndoorEnvironmentObserved(ctx, e)
-		case "weatherobserved":
-			return a.handleWeatherObserved(ctx, e)
-		default:
-			log.Debug().Msgf("unsupported type %s", entity.Type)
-			return nil
+		err = a.spool.replay(ctx, a.replay)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to replay spool")
 		}
 	}
+}
 
-	return nil
+// replay stores a notification read from the spool. The entities are decoded again, but a notification
+// that fails during replay is left in the spool rather than written to it once more.
+func (a *app) replay(ctx context.Context, n Notification) error {
+	_, b, _ := a.decode(ctx, n)
+
+	if len(b.observations) == 0 {
+		return nil
+	}
+
+	_, err := a.store(ctx, b)
+	return err
 }
 
-func (a app) handleIndoorEnvironmentObserved(ctx context.Context, j json.RawMessage) error {
+func (a *app) DeadLetters(ctx context.Context, offset, limit int) ([]DeadLetter, error) {
+	return a.storage.DeadLetters(ctx, offset, limit)
+}
+
+func (a *app) DeadLetter(ctx context.Context, id int64) (DeadLetter, error) {
+	return a.storage.DeadLetter(ctx, id)
+}
+
+// ReprocessDeadLetter handles a dead letter as if it had just been received and removes it once it
+// has been stored. A dead letter that is still invalid, or that fails to store, is kept as it is.
+func (a *app) ReprocessDeadLetter(ctx context.Context, id int64) (EntityResult, error) {
 	log := logging.GetFromContext(ctx)
-	ieo := IndoorEnvironmentObserved{}
-	err := json.Unmarshal(j, &ieo)
+
+	dl, err := a.storage.DeadLetter(ctx, id)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to unmarshal notification entity into indoorEnvironmentObserved")
+		return EntityResult{}, err
 	}
 
-	log.Debug().Msgf("handle %s", ieo.Id)
+	r, o := a.handleEntity(ctx, 0, dl.Entity)
+	if o == nil {
+		return r, nil
+	}
 
-	return a.storage.StoreIndoorEnvironmentObserved(ctx, ieo)
+	stored, err := a.storage.Store(ctx, []Observation{o})
+	if err != nil {
+		r.Status = EntityFailed
+		r.Error = err.Error()
+		return r, err
+	}
+
+	r.Status = EntityStored
+	if stored.Duplicates[0] {
+		r.Status = EntityDuplicate
+	}
+
+	if a.alerts != nil {
+		a.alerts.observe(ctx, []Observation{o}, stored.Duplicates)
+	}
+
+	err = a.storage.DeleteDeadLetter(ctx, id)
+	if err != nil {
+		return r, err
+	}
+
+	log.Info().Msgf("dead letter %d for %s reprocessed", id, r.Id)
+
+	return r, nil
 }
 
-func (a app) handleWeatherObserved(ctx context.Context, j json.RawMessage) error {
-	log := logging.GetFromContext(ctx)
-	wo := WeatherObserved{}
-	err := json.Unmarshal(j, &wo)
+func (a *app) DiscardDeadLetter(ctx context.Context, id int64) error {
+	return a.storage.DeleteDeadLetter(ctx, id)
+}
+
+func (a *app) WaterMeters(ctx context.Context, offset, limit int) ([]WaterMeter, error) {
+	return a.storage.WaterMeters(ctx, offset, limit)
+}
+
+func (a *app) WaterMeter(ctx context.Context, id string) (WaterMeter, error) {
+	return a.storage.WaterMeter(ctx, id)
+}
+
+func (a *app) Series(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error {
+	return a.storage.Series(ctx, q, fn)
+}
+
+// Features returns a page of the latest observations that match the query, or an error wrapping ErrInvalidQuery.
+// The query must have a limit, see ExportFeatures for all of them.
+func (a *app) Features(ctx context.Context, q FeatureQuery) ([]Feature, error) {
+	err := q.check()
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Limit < 1 {
+		return nil, fmt.Errorf("%w: a limit is required", ErrInvalidQuery)
+	}
+
+	features := []Feature{}
+
+	err = a.storage.Features(ctx, q, func(f Feature) error {
+		features = append(features, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return features, nil
+}
+
+// ExportFeatures calls fn with each of the latest observations that match the query as they are read, so that
+// large layers do not have to be kept in memory, or returns an error wrapping ErrInvalidQuery before calling it
+func (a *app) ExportFeatures(ctx context.Context, q FeatureQuery, fn func(Feature) error) error {
+	err := q.check()
 	if err != nil {
-		log.Error().Err(err).Msg("failed to unmarshal notification entity into weatherObserved")
+		return err
 	}
 
-	log.Debug().Msgf("handle %s", wo.Id)
+	return a.storage.Features(ctx, q, fn)
+}
+
+// Close waits for queued notifications to be stored and alerts to be sent, and stops replaying the spool and detecting leaks
+func (a *app) Close() {
+	if a.queue != nil {
+		a.queue.close()
+	}
 
-	return a.storage.StoreWeatherObserved(ctx, wo)
+	close(a.done)
+	a.wg.Wait()
+
+	if a.spool != nil {
+		a.spool.close()
+	}
+
+	if a.alerts != nil {
+		a.alerts.close()
+	}
 }
 
-func (a app) handleWaterConsumptionObserved(ctx context.Context, j json.RawMessage) error {
+// handleEntity decodes and validates an entity. An observation is returned if the entity should be stored,
+// which, depending on the validation mode, may have been corrected or flagged with the rules it violates.
+// Values are converted to the configured units.
+func (a *app) handleEntity(ctx context.Context, i int, e json.RawMessage) (EntityResult, Observation) {
 	log := logging.GetFromContext(ctx)
-	wco := WaterConsumptionObserved{}
-	err := json.Unmarshal(j, &wco)
+
+	entity := Entity{}
+	err := json.Unmarshal(e, &entity)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to unmarshal notification entity into waterConsumptionObserved")
+		log.Error().Err(err).Msgf("unable to unmarshal entity [%d] in notification", i)
+		return EntityResult{Index: i, Status: EntityInvalid, Error: err.Error()}, nil
+	}
+
+	r := EntityResult{Index: i, Id: entity.Id, Type: entity.Type}
+
+	handle, ok := a.handlers.Handler(entity.Type)
+	if !ok {
+		log.Debug().Msgf("unsupported type %s", entity.Type)
+		r.Status = EntityUnsupported
+		return r, nil
 	}
 
-	log.Debug().Msgf("handle %s", wco.Id)
+	log.Debug().Msgf("handle %s", entity.Id)
+
+	o, err := handle(ctx, e)
+	if err == nil {
+		o, err = a.validation.check(ctx, entity.Type, o)
+	}
+	if err != nil {
+		r.Status = EntityInvalid
+		r.Error = err.Error()
+		return r, nil
+	}
 
-	return a.storage.StoreWaterConsumptionObserved(ctx, wco)
+	return r, a.validation.normalise(o)
 }
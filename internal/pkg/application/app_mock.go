@@ -18,9 +18,39 @@ var _ App = &AppMock{}
 //
 //		// make and configure a mocked App
 //		mockedApp := &AppMock{
-//			NotificationReceivedFunc: func(ctx context.Context, n Notification) error {
+//			CloseFunc: func() {
+//				panic("mock out the Close method")
+//			},
+//			DeadLetterFunc: func(ctx context.Context, id int64) (DeadLetter, error) {
+//				panic("mock out the DeadLetter method")
+//			},
+//			DeadLettersFunc: func(ctx context.Context, offset int, limit int) ([]DeadLetter, error) {
+//				panic("mock out the DeadLetters method")
+//			},
+//			DiscardDeadLetterFunc: func(ctx context.Context, id int64) error {
+//				panic("mock out the DiscardDeadLetter method")
+//			},
+//			ExportFeaturesFunc: func(ctx context.Context, q FeatureQuery, fn func(Feature) error) error {
+//				panic("mock out the ExportFeatures method")
+//			},
+//			FeaturesFunc: func(ctx context.Context, q FeatureQuery) ([]Feature, error) {
+//				panic("mock out the Features method")
+//			},
+//			NotificationReceivedFunc: func(ctx context.Context, n Notification) (NotificationResult, error) {
 //				panic("mock out the NotificationReceived method")
 //			},
+//			ReprocessDeadLetterFunc: func(ctx context.Context, id int64) (EntityResult, error) {
+//				panic("mock out the ReprocessDeadLetter method")
+//			},
+//			SeriesFunc: func(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error {
+//				panic("mock out the Series method")
+//			},
+//			WaterMeterFunc: func(ctx context.Context, id string) (WaterMeter, error) {
+//				panic("mock out the WaterMeter method")
+//			},
+//			WaterMetersFunc: func(ctx context.Context, offset int, limit int) ([]WaterMeter, error) {
+//				panic("mock out the WaterMeters method")
+//			},
 //		}
 //
 //		// use mockedApp in code that requires App
@@ -28,11 +58,83 @@ var _ App = &AppMock{}
 //
 //	}
 type AppMock struct {
+	// CloseFunc mocks the Close method.
+	CloseFunc func()
+
+	// DeadLetterFunc mocks the DeadLetter method.
+	DeadLetterFunc func(ctx context.Context, id int64) (DeadLetter, error)
+
+	// DeadLettersFunc mocks the DeadLetters method.
+	DeadLettersFunc func(ctx context.Context, offset int, limit int) ([]DeadLetter, error)
+
+	// DiscardDeadLetterFunc mocks the DiscardDeadLetter method.
+	DiscardDeadLetterFunc func(ctx context.Context, id int64) error
+
+	// ExportFeaturesFunc mocks the ExportFeatures method.
+	ExportFeaturesFunc func(ctx context.Context, q FeatureQuery, fn func(Feature) error) error
+
+	// FeaturesFunc mocks the Features method.
+	FeaturesFunc func(ctx context.Context, q FeatureQuery) ([]Feature, error)
+
 	// NotificationReceivedFunc mocks the NotificationReceived method.
-	NotificationReceivedFunc func(ctx context.Context, n Notification) error
+	NotificationReceivedFunc func(ctx context.Context, n Notification) (NotificationResult, error)
+
+	// ReprocessDeadLetterFunc mocks the ReprocessDeadLetter method.
+	ReprocessDeadLetterFunc func(ctx context.Context, id int64) (EntityResult, error)
+
+	// SeriesFunc mocks the Series method.
+	SeriesFunc func(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error
+
+	// WaterMeterFunc mocks the WaterMeter method.
+	WaterMeterFunc func(ctx context.Context, id string) (WaterMeter, error)
+
+	// WaterMetersFunc mocks the WaterMeters method.
+	WaterMetersFunc func(ctx context.Context, offset int, limit int) ([]WaterMeter, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// Close holds details about calls to the Close method.
+		Close []struct {
+		}
+		// DeadLetter holds details about calls to the DeadLetter method.
+		DeadLetter []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// ID is the id argument value.
+			ID int64
+		}
+		// DeadLetters holds details about calls to the DeadLetters method.
+		DeadLetters []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Offset is the offset argument value.
+			Offset int
+			// Limit is the limit argument value.
+			Limit int
+		}
+		// DiscardDeadLetter holds details about calls to the DiscardDeadLetter method.
+		DiscardDeadLetter []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// ID is the id argument value.
+			ID int64
+		}
+		// ExportFeatures holds details about calls to the ExportFeatures method.
+		ExportFeatures []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Q is the q argument value.
+			Q FeatureQuery
+			// Fn is the fn argument value.
+			Fn func(Feature) error
+		}
+		// Features holds details about calls to the Features method.
+		Features []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Q is the q argument value.
+			Q FeatureQuery
+		}
 		// NotificationReceived holds details about calls to the NotificationReceived method.
 		NotificationReceived []struct {
 			// Ctx is the ctx argument value.
@@ -40,12 +142,269 @@ type AppMock struct {
 			// N is the n argument value.
 			N Notification
 		}
+		// ReprocessDeadLetter holds details about calls to the ReprocessDeadLetter method.
+		ReprocessDeadLetter []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// ID is the id argument value.
+			ID int64
+		}
+		// Series holds details about calls to the Series method.
+		Series []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Q is the q argument value.
+			Q SeriesQuery
+			// Fn is the fn argument value.
+			Fn func(SeriesPoint) error
+		}
+		// WaterMeter holds details about calls to the WaterMeter method.
+		WaterMeter []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// ID is the id argument value.
+			ID string
+		}
+		// WaterMeters holds details about calls to the WaterMeters method.
+		WaterMeters []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Offset is the offset argument value.
+			Offset int
+			// Limit is the limit argument value.
+			Limit int
+		}
 	}
+	lockClose                sync.RWMutex
+	lockDeadLetter           sync.RWMutex
+	lockDeadLetters          sync.RWMutex
+	lockDiscardDeadLetter    sync.RWMutex
+	lockExportFeatures       sync.RWMutex
+	lockFeatures             sync.RWMutex
 	lockNotificationReceived sync.RWMutex
+	lockReprocessDeadLetter  sync.RWMutex
+	lockSeries               sync.RWMutex
+	lockWaterMeter           sync.RWMutex
+	lockWaterMeters          sync.RWMutex
+}
+
+// Close calls CloseFunc.
+func (mock *AppMock) Close() {
+	if mock.CloseFunc == nil {
+		panic("AppMock.CloseFunc: method is nil but App.Close was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockClose.Lock()
+	mock.calls.Close = append(mock.calls.Close, callInfo)
+	mock.lockClose.Unlock()
+	mock.CloseFunc()
+}
+
+// CloseCalls gets all the calls that were made to Close.
+// Check the length with:
+//
+//	len(mockedApp.CloseCalls())
+func (mock *AppMock) CloseCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockClose.RLock()
+	calls = mock.calls.Close
+	mock.lockClose.RUnlock()
+	return calls
+}
+
+// DeadLetter calls DeadLetterFunc.
+func (mock *AppMock) DeadLetter(ctx context.Context, id int64) (DeadLetter, error) {
+	if mock.DeadLetterFunc == nil {
+		panic("AppMock.DeadLetterFunc: method is nil but App.DeadLetter was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		ID  int64
+	}{
+		Ctx: ctx,
+		ID:  id,
+	}
+	mock.lockDeadLetter.Lock()
+	mock.calls.DeadLetter = append(mock.calls.DeadLetter, callInfo)
+	mock.lockDeadLetter.Unlock()
+	return mock.DeadLetterFunc(ctx, id)
+}
+
+// DeadLetterCalls gets all the calls that were made to DeadLetter.
+// Check the length with:
+//
+//	len(mockedApp.DeadLetterCalls())
+func (mock *AppMock) DeadLetterCalls() []struct {
+	Ctx context.Context
+	ID  int64
+} {
+	var calls []struct {
+		Ctx context.Context
+		ID  int64
+	}
+	mock.lockDeadLetter.RLock()
+	calls = mock.calls.DeadLetter
+	mock.lockDeadLetter.RUnlock()
+	return calls
+}
+
+// DeadLetters calls DeadLettersFunc.
+func (mock *AppMock) DeadLetters(ctx context.Context, offset int, limit int) ([]DeadLetter, error) {
+	if mock.DeadLettersFunc == nil {
+		panic("AppMock.DeadLettersFunc: method is nil but App.DeadLetters was just called")
+	}
+	callInfo := struct {
+		Ctx    context.Context
+		Offset int
+		Limit  int
+	}{
+		Ctx:    ctx,
+		Offset: offset,
+		Limit:  limit,
+	}
+	mock.lockDeadLetters.Lock()
+	mock.calls.DeadLetters = append(mock.calls.DeadLetters, callInfo)
+	mock.lockDeadLetters.Unlock()
+	return mock.DeadLettersFunc(ctx, offset, limit)
+}
+
+// DeadLettersCalls gets all the calls that were made to DeadLetters.
+// Check the length with:
+//
+//	len(mockedApp.DeadLettersCalls())
+func (mock *AppMock) DeadLettersCalls() []struct {
+	Ctx    context.Context
+	Offset int
+	Limit  int
+} {
+	var calls []struct {
+		Ctx    context.Context
+		Offset int
+		Limit  int
+	}
+	mock.lockDeadLetters.RLock()
+	calls = mock.calls.DeadLetters
+	mock.lockDeadLetters.RUnlock()
+	return calls
+}
+
+// DiscardDeadLetter calls DiscardDeadLetterFunc.
+func (mock *AppMock) DiscardDeadLetter(ctx context.Context, id int64) error {
+	if mock.DiscardDeadLetterFunc == nil {
+		panic("AppMock.DiscardDeadLetterFunc: method is nil but App.DiscardDeadLetter was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		ID  int64
+	}{
+		Ctx: ctx,
+		ID:  id,
+	}
+	mock.lockDiscardDeadLetter.Lock()
+	mock.calls.DiscardDeadLetter = append(mock.calls.DiscardDeadLetter, callInfo)
+	mock.lockDiscardDeadLetter.Unlock()
+	return mock.DiscardDeadLetterFunc(ctx, id)
+}
+
+// DiscardDeadLetterCalls gets all the calls that were made to DiscardDeadLetter.
+// Check the length with:
+//
+//	len(mockedApp.DiscardDeadLetterCalls())
+func (mock *AppMock) DiscardDeadLetterCalls() []struct {
+	Ctx context.Context
+	ID  int64
+} {
+	var calls []struct {
+		Ctx context.Context
+		ID  int64
+	}
+	mock.lockDiscardDeadLetter.RLock()
+	calls = mock.calls.DiscardDeadLetter
+	mock.lockDiscardDeadLetter.RUnlock()
+	return calls
+}
+
+// ExportFeatures calls ExportFeaturesFunc.
+func (mock *AppMock) ExportFeatures(ctx context.Context, q FeatureQuery, fn func(Feature) error) error {
+	if mock.ExportFeaturesFunc == nil {
+		panic("AppMock.ExportFeaturesFunc: method is nil but App.ExportFeatures was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		Q   FeatureQuery
+		Fn  func(Feature) error
+	}{
+		Ctx: ctx,
+		Q:   q,
+		Fn:  fn,
+	}
+	mock.lockExportFeatures.Lock()
+	mock.calls.ExportFeatures = append(mock.calls.ExportFeatures, callInfo)
+	mock.lockExportFeatures.Unlock()
+	return mock.ExportFeaturesFunc(ctx, q, fn)
+}
+
+// ExportFeaturesCalls gets all the calls that were made to ExportFeatures.
+// Check the length with:
+//
+//	len(mockedApp.ExportFeaturesCalls())
+func (mock *AppMock) ExportFeaturesCalls() []struct {
+	Ctx context.Context
+	Q   FeatureQuery
+	Fn  func(Feature) error
+} {
+	var calls []struct {
+		Ctx context.Context
+		Q   FeatureQuery
+		Fn  func(Feature) error
+	}
+	mock.lockExportFeatures.RLock()
+	calls = mock.calls.ExportFeatures
+	mock.lockExportFeatures.RUnlock()
+	return calls
+}
+
+// Features calls FeaturesFunc.
+func (mock *AppMock) Features(ctx context.Context, q FeatureQuery) ([]Feature, error) {
+	if mock.FeaturesFunc == nil {
+		panic("AppMock.FeaturesFunc: method is nil but App.Features was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		Q   FeatureQuery
+	}{
+		Ctx: ctx,
+		Q:   q,
+	}
+	mock.lockFeatures.Lock()
+	mock.calls.Features = append(mock.calls.Features, callInfo)
+	mock.lockFeatures.Unlock()
+	return mock.FeaturesFunc(ctx, q)
+}
+
+// FeaturesCalls gets all the calls that were made to Features.
+// Check the length with:
+//
+//	len(mockedApp.FeaturesCalls())
+func (mock *AppMock) FeaturesCalls() []struct {
+	Ctx context.Context
+	Q   FeatureQuery
+} {
+	var calls []struct {
+		Ctx context.Context
+		Q   FeatureQuery
+	}
+	mock.lockFeatures.RLock()
+	calls = mock.calls.Features
+	mock.lockFeatures.RUnlock()
+	return calls
 }
 
 // NotificationReceived calls NotificationReceivedFunc.
-func (mock *AppMock) NotificationReceived(ctx context.Context, n Notification) error {
+func (mock *AppMock) NotificationReceived(ctx context.Context, n Notification) (NotificationResult, error) {
 	if mock.NotificationReceivedFunc == nil {
 		panic("AppMock.NotificationReceivedFunc: method is nil but App.NotificationReceived was just called")
 	}
@@ -79,3 +438,155 @@ func (mock *AppMock) NotificationReceivedCalls() []struct {
 	mock.lockNotificationReceived.RUnlock()
 	return calls
 }
+
+// ReprocessDeadLetter calls ReprocessDeadLetterFunc.
+func (mock *AppMock) ReprocessDeadLetter(ctx context.Context, id int64) (EntityResult, error) {
+	if mock.ReprocessDeadLetterFunc == nil {
+		panic("AppMock.ReprocessDeadLetterFunc: method is nil but App.ReprocessDeadLetter was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		ID  int64
+	}{
+		Ctx: ctx,
+		ID:  id,
+	}
+	mock.lockReprocessDeadLetter.Lock()
+	mock.calls.ReprocessDeadLetter = append(mock.calls.ReprocessDeadLetter, callInfo)
+	mock.lockReprocessDeadLetter.Unlock()
+	return mock.ReprocessDeadLetterFunc(ctx, id)
+}
+
+// ReprocessDeadLetterCalls gets all the calls that were made to ReprocessDeadLetter.
+// Check the length with:
+//
+//	len(mockedApp.ReprocessDeadLetterCalls())
+func (mock *AppMock) ReprocessDeadLetterCalls() []struct {
+	Ctx context.Context
+	ID  int64
+} {
+	var calls []struct {
+		Ctx context.Context
+		ID  int64
+	}
+	mock.lockReprocessDeadLetter.RLock()
+	calls = mock.calls.ReprocessDeadLetter
+	mock.lockReprocessDeadLetter.RUnlock()
+	return calls
+}
+
+// Series calls SeriesFunc.
+func (mock *AppMock) Series(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error {
+	if mock.SeriesFunc == nil {
+		panic("AppMock.SeriesFunc: method is nil but App.Series was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		Q   SeriesQuery
+		Fn  func(SeriesPoint) error
+	}{
+		Ctx: ctx,
+		Q:   q,
+		Fn:  fn,
+	}
+	mock.lockSeries.Lock()
+	mock.calls.Series = append(mock.calls.Series, callInfo)
+	mock.lockSeries.Unlock()
+	return mock.SeriesFunc(ctx, q, fn)
+}
+
+// SeriesCalls gets all the calls that were made to Series.
+// Check the length with:
+//
+//	len(mockedApp.SeriesCalls())
+func (mock *AppMock) SeriesCalls() []struct {
+	Ctx context.Context
+	Q   SeriesQuery
+	Fn  func(SeriesPoint) error
+} {
+	var calls []struct {
+		Ctx context.Context
+		Q   SeriesQuery
+		Fn  func(SeriesPoint) error
+	}
+	mock.lockSeries.RLock()
+	calls = mock.calls.Series
+	mock.lockSeries.RUnlock()
+	return calls
+}
+
+// WaterMeter calls WaterMeterFunc.
+func (mock *AppMock) WaterMeter(ctx context.Context, id string) (WaterMeter, error) {
+	if mock.WaterMeterFunc == nil {
+		panic("AppMock.WaterMeterFunc: method is nil but App.WaterMeter was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		ID  string
+	}{
+		Ctx: ctx,
+		ID:  id,
+	}
+	mock.lockWaterMeter.Lock()
+	mock.calls.WaterMeter = append(mock.calls.WaterMeter, callInfo)
+	mock.lockWaterMeter.Unlock()
+	return mock.WaterMeterFunc(ctx, id)
+}
+
+// WaterMeterCalls gets all the calls that were made to WaterMeter.
+// Check the length with:
+//
+//	len(mockedApp.WaterMeterCalls())
+func (mock *AppMock) WaterMeterCalls() []struct {
+	Ctx context.Context
+	ID  string
+} {
+	var calls []struct {
+		Ctx context.Context
+		ID  string
+	}
+	mock.lockWaterMeter.RLock()
+	calls = mock.calls.WaterMeter
+	mock.lockWaterMeter.RUnlock()
+	return calls
+}
+
+// WaterMeters calls WaterMetersFunc.
+func (mock *AppMock) WaterMeters(ctx context.Context, offset int, limit int) ([]WaterMeter, error) {
+	if mock.WaterMetersFunc == nil {
+		panic("AppMock.WaterMetersFunc: method is nil but App.WaterMeters was just called")
+	}
+	callInfo := struct {
+		Ctx    context.Context
+		Offset int
+		Limit  int
+	}{
+		Ctx:    ctx,
+		Offset: offset,
+		Limit:  limit,
+	}
+	mock.lockWaterMeters.Lock()
+	mock.calls.WaterMeters = append(mock.calls.WaterMeters, callInfo)
+	mock.lockWaterMeters.Unlock()
+	return mock.WaterMetersFunc(ctx, offset, limit)
+}
+
+// WaterMetersCalls gets all the calls that were made to WaterMeters.
+// Check the length with:
+//
+//	len(mockedApp.WaterMetersCalls())
+func (mock *AppMock) WaterMetersCalls() []struct {
+	Ctx    context.Context
+	Offset int
+	Limit  int
+} {
+	var calls []struct {
+		Ctx    context.Context
+		Offset int
+		Limit  int
+	}
+	mock.lockWaterMeters.RLock()
+	calls = mock.calls.WaterMeters
+	mock.lockWaterMeters.RUnlock()
+	return calls
+}
@@ -3,33 +3,283 @@ package application
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 
 	"github.com/matryer/is"
+	"github.com/rs/zerolog"
 )
 
 func TestWaterConsumptionObserved(t *testing.T) {
 	is, a, _ := setupTest(t)
 
-	err := a.handleWaterConsumptionObserved(context.Background(), createNotification().Entities[0])
+	handle, ok := a.handlers.Handler("WaterConsumptionObserved")
+	is.True(ok)
 
+	o, err := handle(context.Background(), createNotification().Entities[0])
 	is.NoErr(err)
+
+	wco, ok := o.(WaterConsumptionObserved)
+	is.True(ok)
+	is.Equal(wco.WaterConsumption.Value, 191051.0)
+}
+
+func TestWaterConsumptionObservedAlarms(t *testing.T) {
+	is := is.New(t)
+
+	wco := WaterConsumptionObserved{}
+	is.NoErr(json.Unmarshal(createNotification().Entities[0], &wco))
+
+	is.Equal(wco.AlarmStopsLeaks.Value, false)
+	is.Equal(wco.AlarmMetrology.Value, true)
+	is.Equal(wco.ModuleTampered.Value, true)
+	is.Equal(wco.AlarmFlowPersistence.Value, "Nothing to report")
+	is.Equal(wco.PersistenceFlowDuration.Value, "3h < 6h")
+	is.Equal(wco.MaxFlow.Value, 620.0)
+	is.Equal(wco.MinFlow.UnitCode, "E32")
+
+	_, args := wco.insert(insertOptions{schema: "geodata_vattenmatare", source: "source"})
+	is.Equal(*args[6].(*bool), false)                      // alarmStopsLeaks
+	is.Equal(*args[13].(*bool), true)                      // moduleTampered
+	is.Equal(*args[15].(*float64), 620.0)                  // maxFlow
+	is.Equal(*args[19].(*string), "urn:ngsi-ld:Device:01") // observedBy
+}
+
+func TestThatMissingAlarmsAreStoredAsNull(t *testing.T) {
+	is := is.New(t)
+
+	wco := WaterConsumptionObserved{}
+	is.NoErr(json.Unmarshal([]byte(`{"id":"urn:ngsi-ld:WaterConsumptionObserved:01","alarmTamper":{"value":true}}`), &wco))
+
+	is.Equal(wco.AlarmTamper.Value, true)
+
+	_, args := wco.insert(insertOptions{schema: "geodata_vattenmatare", source: "source"})
+	is.Equal(args[6].(*bool), (*bool)(nil))        // alarmStopsLeaks
+	is.Equal(args[15].(*float64), (*float64)(nil)) // maxFlow
+	is.Equal(args[19].(*string), (*string)(nil))   // observedBy
 }
 
 func TestIndoorEnvironmentObserved(t *testing.T) {
 	is, a, _ := setupTest(t)
 
-	err := a.handleIndoorEnvironmentObserved(context.Background(), createNotification().Entities[1])
+	handle, ok := a.handlers.Handler("indoorenvironmentobserved")
+	is.True(ok)
 
+	o, err := handle(context.Background(), createNotification().Entities[1])
 	is.NoErr(err)
+
+	_, ok = o.(IndoorEnvironmentObserved)
+	is.True(ok)
 }
 
 func TestWeatherObserved(t *testing.T) {
 	is, a, _ := setupTest(t)
 
-	err := a.handleWeatherObserved(context.Background(), createNotification().Entities[2])
+	handle, ok := a.handlers.Handler("WeatherObserved")
+	is.True(ok)
+
+	o, err := handle(context.Background(), createNotification().Entities[2])
+	is.NoErr(err)
+
+	_, ok = o.(WeatherObserved)
+	is.True(ok)
+}
+
+func TestThatAllEntitiesInNotificationAreHandled(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	result, err := a.NotificationReceived(context.Background(), createNotification())
+
+	is.NoErr(err)
+	is.Equal(result.Stored, 3)
+	is.Equal(len(result.Entities), 3)
+	is.Equal(len(s.(*StorageMock).StoreCalls()), 1) // all observations should be stored in one batch
+	is.Equal(len(s.(*StorageMock).StoreCalls()[0].Obs), 3)
+}
+
+func TestThatEntityOutcomesAreReportedPerEntity(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	s.(*StorageMock).StoreFunc = func(ctx context.Context, obs []Observation) (StoreResult, error) {
+		result := StoreResult{Duplicates: make([]bool, len(obs))}
+		for i, o := range obs {
+			_, result.Duplicates[i] = o.(IndoorEnvironmentObserved)
+		}
+		return result, nil
+	}
+
+	n := createNotification()
+	n.Entities = append(n.Entities,
+		json.RawMessage(`{"id":"urn:ngsi-ld:Device:01","type":"Device"}`),
+		json.RawMessage(`{"id":"urn:ngsi-ld:WaterConsumptionObserved:02","type":"WaterConsumptionObserved","waterConsumption":{"value":"many"}}`),
+	)
+
+	result, err := a.NotificationReceived(context.Background(), n)
+
+	is.NoErr(err)
+	is.Equal(result.Stored, 2)
+	is.Equal(result.Duplicates, 1)
+	is.Equal(result.Unsupported, 1)
+	is.Equal(result.Invalid, 1)
+	is.Equal(result.Entities[1].Status, EntityDuplicate)
+	is.Equal(result.Entities[4].Id, "urn:ngsi-ld:WaterConsumptionObserved:02")
+	is.Equal(len(s.(*StorageMock).StoreCalls()[0].Obs), 4) // unsupported entities should not be stored
+
+	dl, ok := s.(*StorageMock).StoreCalls()[0].Obs[3].(DeadLetter) // invalid entities should be stored as dead letters
+	is.True(ok)
+	is.Equal(dl.EntityId, "urn:ngsi-ld:WaterConsumptionObserved:02")
+	is.Equal(dl.SubscriptionId, "notimplemented")
+	is.True(dl.Error != "")
+}
+
+func TestThatRejectedObservationsAreStoredAsDeadLetters(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	s.(*StorageMock).StoreFunc = func(ctx context.Context, obs []Observation) (StoreResult, error) {
+		if _, ok := obs[2].(DeadLetter); !ok {
+			return StoreResult{}, &ObservationError{Index: 2, Err: &pgconn.PgError{Code: "22007", Message: "invalid input syntax for type timestamp"}}
+		}
+		return StoreResult{Inserted: len(obs), Duplicates: make([]bool, len(obs))}, nil
+	}
+
+	result, err := a.NotificationReceived(context.Background(), createNotification())
+
+	is.NoErr(err)
+	is.Equal(result.Stored, 2)
+	is.Equal(result.Invalid, 1)
+	is.Equal(result.Entities[2].Status, EntityInvalid)
+	is.Equal(len(s.(*StorageMock).StoreCalls()), 2)
+
+	dl := s.(*StorageMock).StoreCalls()[1].Obs[2].(DeadLetter)
+	is.Equal(dl.EntityType, "WeatherObserved")
+	is.True(strings.Contains(dl.Error, "invalid input syntax"))
+}
+
+func TestThatAReprocessedDeadLetterIsRemoved(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	storage := s.(*StorageMock)
+	storage.DeadLetterFunc = func(ctx context.Context, id int64) (DeadLetter, error) {
+		return DeadLetter{Id: id, Entity: createNotification().Entities[0]}, nil
+	}
+	storage.DeleteDeadLetterFunc = func(ctx context.Context, id int64) error {
+		return nil
+	}
+
+	r, err := a.ReprocessDeadLetter(context.Background(), 17)
+
+	is.NoErr(err)
+	is.Equal(r.Status, EntityStored)
+	is.Equal(storage.DeleteDeadLetterCalls()[0].ID, int64(17))
+}
+
+func TestThatAnInvalidDeadLetterIsKept(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	storage := s.(*StorageMock)
+	storage.DeadLetterFunc = func(ctx context.Context, id int64) (DeadLetter, error) {
+		return DeadLetter{Id: id, Entity: json.RawMessage(`{"id":"urn:ngsi-ld:WaterConsumptionObserved:02","type":"WaterConsumptionObserved","waterConsumption":{"value":"many"}}`)}, nil
+	}
+
+	r, err := a.ReprocessDeadLetter(context.Background(), 17)
 
 	is.NoErr(err)
+	is.Equal(r.Status, EntityInvalid)
+	is.Equal(len(storage.StoreCalls()), 0)
+	is.Equal(len(storage.DeleteDeadLetterCalls()), 0)
+}
+
+func TestThatAFailedBatchFailsAllStoredEntities(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	s.(*StorageMock).StoreFunc = func(ctx context.Context, obs []Observation) (StoreResult, error) {
+		return StoreResult{}, errors.New("connection refused")
+	}
+
+	n := createNotification()
+	n.Entities = append(n.Entities, json.RawMessage(`{"id":"urn:ngsi-ld:Device:01","type":"Device"}`))
+
+	result, err := a.NotificationReceived(context.Background(), n)
+
+	is.True(err != nil) // the failed batch should be reported as an error
+	is.Equal(result.Failed, 3)
+	is.Equal(result.Unsupported, 1)
+	is.Equal(result.Entities[0].Error, "failed to store 3 observations: connection refused")
+}
+
+func TestThatQueuedNotificationsAreAcceptedAndStored(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	a.queue = newQueue(QueueConfig{Size: 10, Workers: 2, RetryAfter: time.Second}, a.persist)
+
+	result, err := a.NotificationReceived(context.Background(), createNotification())
+	is.NoErr(err)
+	is.Equal(result.Accepted, 3)
+
+	a.Close()
+
+	is.Equal(len(s.(*StorageMock).StoreCalls()), 1)
+}
+
+func TestThatAFullQueueRejectsNotifications(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	s.(*StorageMock).StoreFunc = func(ctx context.Context, obs []Observation) (StoreResult, error) {
+		started <- struct{}{}
+		<-release
+		return StoreResult{Duplicates: make([]bool, len(obs))}, nil
+	}
+
+	a.queue = newQueue(QueueConfig{Size: 1, Workers: 1, RetryAfter: 5 * time.Second}, a.persist)
+
+	_, err := a.NotificationReceived(context.Background(), createNotification())
+	is.NoErr(err)
+	<-started // the worker is now busy with the first notification
+
+	_, err = a.NotificationReceived(context.Background(), createNotification())
+	is.NoErr(err) // the second notification should fit in the queue
+
+	result, err := a.NotificationReceived(context.Background(), createNotification())
+
+	queueFull := &QueueFullError{}
+	is.True(errors.As(err, &queueFull))
+	is.Equal(queueFull.RetryAfter, 5*time.Second)
+	is.Equal(result.Failed, 3)
+
+	close(release)
+	a.Close()
+}
+
+type deviceObserved struct {
+	Entity
+}
+
+func (d deviceObserved) insert(opts insertOptions) (string, []any) {
+	return "", nil
+}
+
+func TestThatRegisteredHandlersAreUsed(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	a.handlers = NewRegistry()
+	a.handlers.Register("Device", NewEntityHandler[deviceObserved]())
+
+	n := createNotification()
+	n.Entities = append(n.Entities, json.RawMessage(`{"id":"urn:ngsi-ld:Device:01","type":"Device"}`))
+
+	result, err := a.NotificationReceived(context.Background(), n)
+
+	is.NoErr(err)
+	is.Equal(result.Unsupported, 3)
+	is.Equal(result.Stored, 1)
+	is.Equal(s.(*StorageMock).StoreCalls()[0].Obs[0].(deviceObserved).Id, "urn:ngsi-ld:Device:01")
 }
 
 func createNotification() Notification {
@@ -42,22 +292,59 @@ func createNotification() Notification {
 	return n
 }
 
-func setupTest(t *testing.T) (*is.I, app, Storage) {
+func TestThatOnlyExportedFeaturesAreUnlimited(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	s.(*StorageMock).FeaturesFunc = func(ctx context.Context, q FeatureQuery, fn func(Feature) error) error {
+		return fn(Feature{Type: "Feature", Id: "urn:ngsi-ld:WeatherObserved:01"})
+	}
+
+	_, err := a.Features(context.Background(), FeatureQuery{Types: []string{"WeatherObserved"}})
+	is.True(errors.Is(err, ErrInvalidQuery))
+
+	features, err := a.Features(context.Background(), FeatureQuery{Types: []string{"WeatherObserved"}, Limit: 10})
+	is.NoErr(err)
+	is.Equal(len(features), 1)
+
+	exported := 0
+	is.NoErr(a.ExportFeatures(context.Background(), FeatureQuery{Types: []string{"WeatherObserved"}}, func(Feature) error {
+		exported++
+		return nil
+	}))
+	is.Equal(exported, 1)
+}
+
+func TestThatTheQueueRequiresASpool(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv("INGEST_QUEUE_ENABLED", "true")
+
+	_, err := LoadConfig(zerolog.Nop())
+	is.True(err != nil) // accepted notifications could be lost
+
+	t.Setenv("SPOOL_DIR", t.TempDir())
+
+	cfg, err := LoadConfig(zerolog.Nop())
+	is.NoErr(err)
+	is.True(cfg.Queue.Enabled)
+
+	_, err = New(&StorageMock{}, Config{Queue: QueueConfig{Enabled: true, Size: 1, Workers: 1}})
+	is.True(err != nil)
+}
+
+func setupTest(t *testing.T) (*is.I, *app, Storage) {
 	is := is.New(t)
 
 	s := &StorageMock{
-		StoreIndoorEnvironmentObservedFunc: func(ctx context.Context, i IndoorEnvironmentObserved) error {
-			return nil
-		},
-		StoreWaterConsumptionObservedFunc: func(ctx context.Context, w WaterConsumptionObserved) error {
-			return nil
-		},
-		StoreWeatherObservedFunc: func(ctx context.Context, w WeatherObserved) error {
-			return nil
+		StoreFunc: func(ctx context.Context, obs []Observation) (StoreResult, error) {
+			return StoreResult{Inserted: len(obs), Duplicates: make([]bool, len(obs))}, nil
 		},
 	}
-	a := app{
-		storage: s,
+	a := &app{
+		storage:    s,
+		handlers:   defaultRegistry,
+		validation: newValidation(ValidationConfig{Mode: ValidationReject}),
+		done:       make(chan struct{}),
 	}
 
 	return is, a, s
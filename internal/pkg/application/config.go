@@ -0,0 +1,227 @@
+package application
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/env"
+	"github.com/rs/zerolog"
+)
+
+type Config struct {
+	Queue         QueueConfig
+	Spool         SpoolConfig
+	Validation    ValidationConfig
+	LeakDetection LeakDetectionConfig
+	Alerting      AlertingConfig
+}
+
+// QueueConfig configures storing notifications asynchronously after they have been accepted. It requires a spool,
+// since accepted notifications that can not be stored would otherwise be lost.
+type QueueConfig struct {
+	Enabled    bool
+	Size       int
+	Workers    int
+	RetryAfter time.Duration
+}
+
+// SpoolConfig configures where notifications are kept when they can not be stored. The spool is disabled if Dir is empty.
+type SpoolConfig struct {
+	Dir            string
+	MaxBytes       int64
+	SegmentBytes   int64
+	ReplayInterval time.Duration
+}
+
+type ValidationConfig struct {
+	Mode ValidationMode
+	// ConsumptionUnit is the UN/CEFACT code of the unit that water consumption is stored in
+	ConsumptionUnit string
+}
+
+// LeakDetectionConfig configures the analysis of stored consumption series. Flows are in the unit of the
+// consumption per hour and night hours are in the configured time zone.
+type LeakDetectionConfig struct {
+	Enabled     bool
+	Interval    time.Duration
+	Window      time.Duration
+	Location    *time.Location
+	NightStart  int
+	NightEnd    int
+	MinFlow     float64
+	StepFactor  float64
+	Persistence time.Duration
+}
+
+// AlertingConfig configures the webhooks that are sent when meter alarms change. Alerting is disabled if there are no rules.
+type AlertingConfig struct {
+	Rules          []AlertRule
+	QueueSize      int
+	Timeout        time.Duration
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// LoadConfig reads the application configuration from the environment
+func LoadConfig(log zerolog.Logger) (Config, error) {
+	cfg := Config{}
+	var err error
+
+	cfg.Queue.Enabled, err = strconv.ParseBool(env.GetVariableOrDefault(log, "INGEST_QUEUE_ENABLED", "false"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid INGEST_QUEUE_ENABLED: %w", err)
+	}
+
+	cfg.Queue.Size, err = strconv.Atoi(env.GetVariableOrDefault(log, "INGEST_QUEUE_SIZE", "1000"))
+	if err != nil || cfg.Queue.Size < 1 {
+		return cfg, fmt.Errorf("invalid INGEST_QUEUE_SIZE, expected a positive number")
+	}
+
+	cfg.Queue.Workers, err = strconv.Atoi(env.GetVariableOrDefault(log, "INGEST_QUEUE_WORKERS", "4"))
+	if err != nil || cfg.Queue.Workers < 1 {
+		return cfg, fmt.Errorf("invalid INGEST_QUEUE_WORKERS, expected a positive number")
+	}
+
+	cfg.Queue.RetryAfter, err = time.ParseDuration(env.GetVariableOrDefault(log, "INGEST_QUEUE_RETRY_AFTER", "5s"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid INGEST_QUEUE_RETRY_AFTER: %w", err)
+	}
+
+	cfg.Spool.Dir = env.GetVariableOrDefault(log, "SPOOL_DIR", "")
+
+	cfg.Spool.MaxBytes, err = strconv.ParseInt(env.GetVariableOrDefault(log, "SPOOL_MAX_BYTES", "1073741824"), 10, 64)
+	if err != nil || cfg.Spool.MaxBytes < 1 {
+		return cfg, fmt.Errorf("invalid SPOOL_MAX_BYTES, expected a positive number")
+	}
+
+	cfg.Spool.SegmentBytes, err = strconv.ParseInt(env.GetVariableOrDefault(log, "SPOOL_SEGMENT_BYTES", "8388608"), 10, 64)
+	if err != nil || cfg.Spool.SegmentBytes < 1 {
+		return cfg, fmt.Errorf("invalid SPOOL_SEGMENT_BYTES, expected a positive number")
+	}
+
+	cfg.Spool.ReplayInterval, err = time.ParseDuration(env.GetVariableOrDefault(log, "SPOOL_REPLAY_INTERVAL", "30s"))
+	if err != nil || cfg.Spool.ReplayInterval <= 0 {
+		return cfg, fmt.Errorf("invalid SPOOL_REPLAY_INTERVAL, expected a positive duration")
+	}
+
+	if cfg.Queue.Enabled && cfg.Spool.Dir == "" {
+		return cfg, fmt.Errorf("INGEST_QUEUE_ENABLED requires SPOOL_DIR, notifications that are accepted but can not be stored would be lost")
+	}
+
+	// observations that violate a rule are still stored, now with their violations, unless rejection is opted in to
+	cfg.Validation.Mode = ValidationMode(env.GetVariableOrDefault(log, "VALIDATION_MODE", string(ValidationFlag)))
+	switch cfg.Validation.Mode {
+	case ValidationReject, ValidationFlag, ValidationFixup:
+	default:
+		return cfg, fmt.Errorf("invalid VALIDATION_MODE, expected one of %s, %s or %s", ValidationReject, ValidationFlag, ValidationFixup)
+	}
+
+	cfg.Validation.ConsumptionUnit = env.GetVariableOrDefault(log, "WCO_UNIT", defaultConsumptionUnit)
+	if _, ok := volumes[cfg.Validation.ConsumptionUnit]; !ok {
+		return cfg, fmt.Errorf("invalid WCO_UNIT, expected a UN/CEFACT unit of volume such as MTQ or LTR")
+	}
+
+	err = loadLeakDetectionConfig(log, &cfg.LeakDetection)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = loadAlertingConfig(log, &cfg.Alerting)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+func loadLeakDetectionConfig(log zerolog.Logger, cfg *LeakDetectionConfig) error {
+	var err error
+
+	cfg.Enabled, err = strconv.ParseBool(env.GetVariableOrDefault(log, "LEAK_DETECTION_ENABLED", "false"))
+	if err != nil {
+		return fmt.Errorf("invalid LEAK_DETECTION_ENABLED: %w", err)
+	}
+
+	cfg.Interval, err = time.ParseDuration(env.GetVariableOrDefault(log, "LEAK_DETECTION_INTERVAL", "1h"))
+	if err != nil || cfg.Interval <= 0 {
+		return fmt.Errorf("invalid LEAK_DETECTION_INTERVAL, expected a positive duration")
+	}
+
+	cfg.Window, err = time.ParseDuration(env.GetVariableOrDefault(log, "LEAK_DETECTION_WINDOW", "168h"))
+	if err != nil || cfg.Window < 24*time.Hour {
+		return fmt.Errorf("invalid LEAK_DETECTION_WINDOW, expected a duration of at least 24h")
+	}
+
+	cfg.Location, err = time.LoadLocation(env.GetVariableOrDefault(log, "LEAK_DETECTION_TIMEZONE", "Europe/Stockholm"))
+	if err != nil {
+		return fmt.Errorf("invalid LEAK_DETECTION_TIMEZONE: %w", err)
+	}
+
+	cfg.NightStart, err = strconv.Atoi(env.GetVariableOrDefault(log, "LEAK_NIGHT_START", "2"))
+	if err != nil || cfg.NightStart < 0 || cfg.NightStart > 23 {
+		return fmt.Errorf("invalid LEAK_NIGHT_START, expected an hour between 0 and 23")
+	}
+
+	cfg.NightEnd, err = strconv.Atoi(env.GetVariableOrDefault(log, "LEAK_NIGHT_END", "5"))
+	if err != nil || cfg.NightEnd < 0 || cfg.NightEnd > 23 || cfg.NightEnd == cfg.NightStart {
+		return fmt.Errorf("invalid LEAK_NIGHT_END, expected an hour between 0 and 23 other than LEAK_NIGHT_START")
+	}
+
+	cfg.MinFlow, err = strconv.ParseFloat(env.GetVariableOrDefault(log, "LEAK_MIN_FLOW", "1"), 64)
+	if err != nil || cfg.MinFlow <= 0 {
+		return fmt.Errorf("invalid LEAK_MIN_FLOW, expected a positive number")
+	}
+
+	cfg.StepFactor, err = strconv.ParseFloat(env.GetVariableOrDefault(log, "LEAK_STEP_FACTOR", "3"), 64)
+	if err != nil || cfg.StepFactor <= 1 {
+		return fmt.Errorf("invalid LEAK_STEP_FACTOR, expected a number greater than 1")
+	}
+
+	cfg.Persistence, err = time.ParseDuration(env.GetVariableOrDefault(log, "LEAK_PERSISTENCE", "24h"))
+	if err != nil || cfg.Persistence <= 0 {
+		return fmt.Errorf("invalid LEAK_PERSISTENCE, expected a positive duration")
+	}
+
+	return nil
+}
+
+func loadAlertingConfig(log zerolog.Logger, cfg *AlertingConfig) error {
+	var err error
+
+	if path := env.GetVariableOrDefault(log, "ALERT_RULES_FILE", ""); path != "" {
+		cfg.Rules, err = loadAlertRules(path)
+		if err != nil {
+			return fmt.Errorf("invalid ALERT_RULES_FILE: %w", err)
+		}
+	}
+
+	cfg.QueueSize, err = strconv.Atoi(env.GetVariableOrDefault(log, "ALERT_QUEUE_SIZE", "1000"))
+	if err != nil || cfg.QueueSize < 1 {
+		return fmt.Errorf("invalid ALERT_QUEUE_SIZE, expected a positive number")
+	}
+
+	cfg.Timeout, err = time.ParseDuration(env.GetVariableOrDefault(log, "ALERT_TIMEOUT", "10s"))
+	if err != nil || cfg.Timeout <= 0 {
+		return fmt.Errorf("invalid ALERT_TIMEOUT, expected a positive duration")
+	}
+
+	cfg.MaxAttempts, err = strconv.Atoi(env.GetVariableOrDefault(log, "ALERT_MAX_ATTEMPTS", "5"))
+	if err != nil || cfg.MaxAttempts < 1 {
+		return fmt.Errorf("invalid ALERT_MAX_ATTEMPTS, expected a positive number")
+	}
+
+	cfg.InitialBackoff, err = time.ParseDuration(env.GetVariableOrDefault(log, "ALERT_INITIAL_BACKOFF", "1s"))
+	if err != nil || cfg.InitialBackoff <= 0 {
+		return fmt.Errorf("invalid ALERT_INITIAL_BACKOFF, expected a positive duration")
+	}
+
+	cfg.MaxBackoff, err = time.ParseDuration(env.GetVariableOrDefault(log, "ALERT_MAX_BACKOFF", "1m"))
+	if err != nil || cfg.MaxBackoff < cfg.InitialBackoff {
+		return fmt.Errorf("invalid ALERT_MAX_BACKOFF, expected a duration of at least ALERT_INITIAL_BACKOFF")
+	}
+
+	return nil
+}
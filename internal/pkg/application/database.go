@@ -2,89 +2,409 @@ package application
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/diwise/service-chassis/pkg/infrastructure/env"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:generate moq -rm -out database_mock.go . Storage
 
 type Storage interface {
-	StoreWaterConsumptionObserved(ctx context.Context, w WaterConsumptionObserved) error
-	StoreWeatherObserved(ctx context.Context, w WeatherObserved) error
-	StoreIndoorEnvironmentObserved(ctx context.Context, i IndoorEnvironmentObserved) error
+	Store(ctx context.Context, obs []Observation) (StoreResult, error)
+	DeadLetters(ctx context.Context, offset, limit int) ([]DeadLetter, error)
+	DeadLetter(ctx context.Context, id int64) (DeadLetter, error)
+	DeleteDeadLetter(ctx context.Context, id int64) error
+	Meters(ctx context.Context, since time.Time) ([]string, error)
+	Readings(ctx context.Context, id string, since time.Time) ([]Reading, error)
+	StoreLeakSuspicions(ctx context.Context, suspicions []LeakSuspicion) error
+	UpdateAlarms(ctx context.Context, ids []string, update func(state map[AlarmKey]AlarmValue)) error
+	WaterMeters(ctx context.Context, offset, limit int) ([]WaterMeter, error)
+	WaterMeter(ctx context.Context, id string) (WaterMeter, error)
+	Series(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error
+	Features(ctx context.Context, q FeatureQuery, fn func(Feature) error) error
+	Ping(ctx context.Context) error
+	Close()
 }
 
-type storage struct {
-	connUrl string
-	source  string
-	schema  string
+// Observation is a decoded entity that knows which statement and arguments are needed to insert it
+type Observation interface {
+	insert(opts insertOptions) (string, []any)
+}
+
+// observationTables are the tables that observations are stored in
+var observationTables = []string{"waterConsumptionObserved", "indoorEnvironmentObserved", "weatherObserved"}
+
+// insertOptions holds the parts of the storage configuration that are needed to build insert statements
+type insertOptions struct {
+	schema string
+	source string
+	// locationFallback stores the last known location of the same id when an observation has none
+	locationFallback bool
+}
+
+// location returns the expression for a location given the parameter number of its GeoJSON geometry. A missing
+// location is stored as NULL, or as the last known location in table if the fallback is enabled.
+func (opts insertOptions) location(table string, param int) string {
+	geometry := fmt.Sprintf("ST_SetSRID(ST_GeomFromGeoJSON($%d), 4326)", param)
+	if !opts.locationFallback {
+		return geometry
+	}
+
+	return fmt.Sprintf(`COALESCE(%s, (SELECT "location" FROM %s.%s WHERE "id" = $1 AND "location" IS NOT NULL ORDER BY "observedAt" DESC LIMIT 1))`, geometry, opts.schema, table)
+}
+
+// ObservationError is returned by Store when the statement for one of the observations fails
+type ObservationError struct {
+	Index int
+	Err   error
 }
 
-func NewStorage() (Storage, error) {
-	log := zerolog.Logger{}
+func (e *ObservationError) Error() string {
+	return fmt.Sprintf("observation [%d]: %s", e.Index, e.Err.Error())
+}
 
+func (e *ObservationError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent reports whether the observation was rejected because of its data, i.e. a data exception or
+// an integrity constraint violation, meaning that it will never be possible to store it as it is
+func (e *ObservationError) Permanent() bool {
+	var pgErr *pgconn.PgError
+	if errors.As(e.Err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")
+	}
+	return false
+}
+
+// ErrNotFound is returned when a requested item does not exist
+var ErrNotFound = errors.New("not found")
+
+type StoreResult struct {
+	Inserted int
+	Skipped  int
+	// Duplicates reports, in the same order as the stored observations, whether an observation
+	// was skipped because one with the same id and observedAt already exists
+	Duplicates []bool
+}
+
+type StorageConfig struct {
+	connUrl           string
+	Source            string
+	Schema            string
+	MaxConns          int32
+	MinConns          int32
+	HealthCheckPeriod time.Duration
+	ConnectTimeout    time.Duration
+	Migrate           bool
+	LocationFallback  bool
+	// ProjectedSRID is the EPSG code of an additional, projected, location column. It is not added if 0.
+	ProjectedSRID int
+	// ConsumptionUnit is the unit that water consumption is stored in, see Config.Validation. The stored readings
+	// are converted to it the first time it is set, after which it can not be changed.
+	ConsumptionUnit string
+	Timescale       TimescaleConfig
+	Retry           RetryConfig
+}
+
+// LoadStorageConfig reads the database configuration from the environment
+func LoadStorageConfig(log zerolog.Logger) (StorageConfig, error) {
 	var pgUser = env.GetVariableOrDefault(log, "PG_USER", "")
 	var pgPassword = env.GetVariableOrDefault(log, "PG_PASSWORD", "")
 	var pgHostname = env.GetVariableOrDefault(log, "PG_HOSTNAME", "")
 	var pgPort = env.GetVariableOrDefault(log, "PG_PORT", "5432")
 	var pgDatabaseName = env.GetVariableOrDefault(log, "PG_DATABASE", "")
 
-	s := &storage{
-		source:  env.GetVariableOrDefault(log, "WCO_SOURCE", "Göteborgs Stads kretslopp och vattennämnd"),
-		schema:  env.GetVariableOrDefault(log, "DB_SCHEMA", "geodata_vattenmatare"),
+	cfg := StorageConfig{
 		connUrl: fmt.Sprintf("postgres://%s:%s@%s:%s/%s", pgUser, pgPassword, pgHostname, pgPort, pgDatabaseName),
+		Source:  env.GetVariableOrDefault(log, "WCO_SOURCE", "Göteborgs Stads kretslopp och vattennämnd"),
+		Schema:  env.GetVariableOrDefault(log, "DB_SCHEMA", "geodata_vattenmatare"),
+	}
+
+	var err error
+
+	cfg.Migrate, err = strconv.ParseBool(env.GetVariableOrDefault(log, "DB_MIGRATE", "true"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid DB_MIGRATE: %w", err)
+	}
+
+	cfg.LocationFallback, err = strconv.ParseBool(env.GetVariableOrDefault(log, "DB_LOCATION_FALLBACK", "false"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid DB_LOCATION_FALLBACK: %w", err)
+	}
+
+	cfg.ProjectedSRID, err = strconv.Atoi(env.GetVariableOrDefault(log, "DB_PROJECTED_EPSG", "0"))
+	if err != nil || cfg.ProjectedSRID < 0 {
+		return cfg, fmt.Errorf("invalid DB_PROJECTED_EPSG, expected an EPSG code such as 3007")
+	}
+
+	cfg.Timescale.Enabled, err = strconv.ParseBool(env.GetVariableOrDefault(log, "DB_TIMESCALE_ENABLED", "false"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid DB_TIMESCALE_ENABLED: %w", err)
+	}
+
+	cfg.Timescale.ChunkInterval, err = time.ParseDuration(env.GetVariableOrDefault(log, "DB_TIMESCALE_CHUNK_INTERVAL", "168h"))
+	if err != nil || cfg.Timescale.ChunkInterval < time.Minute {
+		return cfg, fmt.Errorf("invalid DB_TIMESCALE_CHUNK_INTERVAL, expected a duration of at least a minute")
+	}
+
+	cfg.Timescale.CompressAfter, err = time.ParseDuration(env.GetVariableOrDefault(log, "DB_TIMESCALE_COMPRESS_AFTER", "0"))
+	if err != nil || cfg.Timescale.CompressAfter < 0 {
+		return cfg, fmt.Errorf("invalid DB_TIMESCALE_COMPRESS_AFTER, expected a positive duration or 0 to disable compression")
+	}
+
+	cfg.Timescale.RetainFor, err = time.ParseDuration(env.GetVariableOrDefault(log, "DB_TIMESCALE_RETAIN_FOR", "0"))
+	if err != nil || cfg.Timescale.RetainFor < 0 {
+		return cfg, fmt.Errorf("invalid DB_TIMESCALE_RETAIN_FOR, expected a positive duration or 0 to keep all observations")
+	}
+
+	maxConns, err := strconv.ParseInt(env.GetVariableOrDefault(log, "PG_MAX_CONNS", "10"), 10, 32)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid PG_MAX_CONNS: %w", err)
+	}
+	cfg.MaxConns = int32(maxConns)
+
+	minConns, err := strconv.ParseInt(env.GetVariableOrDefault(log, "PG_MIN_CONNS", "0"), 10, 32)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid PG_MIN_CONNS: %w", err)
 	}
+	cfg.MinConns = int32(minConns)
 
-	ctx := context.Background()
-	dbpool, err := pgxpool.New(ctx, s.connUrl)
+	cfg.HealthCheckPeriod, err = time.ParseDuration(env.GetVariableOrDefault(log, "PG_HEALTH_CHECK_PERIOD", "1m"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid PG_HEALTH_CHECK_PERIOD: %w", err)
+	}
+
+	cfg.ConnectTimeout, err = time.ParseDuration(env.GetVariableOrDefault(log, "PG_CONNECT_TIMEOUT", "10s"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid PG_CONNECT_TIMEOUT: %w", err)
+	}
+
+	cfg.Retry.MaxAttempts, err = strconv.Atoi(env.GetVariableOrDefault(log, "PG_RETRY_MAX_ATTEMPTS", "5"))
+	if err != nil || cfg.Retry.MaxAttempts < 1 {
+		return cfg, fmt.Errorf("invalid PG_RETRY_MAX_ATTEMPTS, expected a positive number")
+	}
+
+	cfg.Retry.InitialBackoff, err = time.ParseDuration(env.GetVariableOrDefault(log, "PG_RETRY_INITIAL_BACKOFF", "100ms"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid PG_RETRY_INITIAL_BACKOFF: %w", err)
+	}
+
+	cfg.Retry.MaxBackoff, err = time.ParseDuration(env.GetVariableOrDefault(log, "PG_RETRY_MAX_BACKOFF", "5s"))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid PG_RETRY_MAX_BACKOFF: %w", err)
+	}
+
+	return cfg, nil
+}
+
+type storage struct {
+	pool             *pgxpool.Pool
+	retrier          *retrier
+	source           string
+	schema           string
+	locationFallback bool
+}
+
+// NewStorage creates a connection pool that is shared by all queries and verifies that the database
+// is reachable before returning. Pending migrations are applied unless disabled in the configuration,
+// after which the observation tables are set up for projected locations and time-series storage if
+// configured, and stored readings are converted to the configured unit. The pool is released by calling Close.
+func NewStorage(ctx context.Context, cfg StorageConfig) (Storage, error) {
+	pool, err := connect(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
-	defer dbpool.Close()
 
-	return s, nil
+	if cfg.Migrate {
+		err = migrate(ctx, pool, cfg.Schema)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+
+	// the projected location is added before the tables are compressed
+	if cfg.Migrate || cfg.ProjectedSRID != 0 {
+		err = project(ctx, pool, cfg.Schema, cfg.ProjectedSRID)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+
+	if cfg.Timescale.Enabled {
+		err = timescale(ctx, pool, cfg.Schema, cfg.Timescale)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+
+	if cfg.ConsumptionUnit != "" {
+		err = storeConsumptionIn(ctx, pool, cfg.Schema, cfg.ConsumptionUnit)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+
+	return &storage{
+		pool:             pool,
+		retrier:          newRetrier(cfg.Retry),
+		source:           cfg.Source,
+		schema:           cfg.Schema,
+		locationFallback: cfg.LocationFallback,
+	}, nil
 }
 
-func (s *storage) StoreWaterConsumptionObserved(ctx context.Context, wco WaterConsumptionObserved) error {
-	var x, y float64 = 0.0, 0.0
-	if wco.Location.Value.Coordinates != nil && len(wco.Location.Value.Coordinates) > 1 {
-		x = wco.Location.Value.Coordinates[0]
-		y = wco.Location.Value.Coordinates[1]
+func connect(ctx context.Context, cfg StorageConfig) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.connUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	poolConfig.MaxConns = cfg.MaxConns
+	poolConfig.MinConns = cfg.MinConns
+	poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	poolConfig.ConnConfig.ConnectTimeout = cfg.ConnectTimeout
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
+
+	err = pool.Ping(pingCtx)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
 
-	sql := fmt.Sprintf(`INSERT INTO %s.waterConsumptionObserved ("id", "waterConsumption", "unitCode", "observedAt", "location", "source", "createdAt") VALUES ($1, $2, $3, $4, ST_MakePoint($5,$6), $7, current_timestamp) ON CONFLICT DO NOTHING;`, s.schema)
+	return pool, nil
+}
 
-	return s.exec(ctx, sql, wco.Id, wco.WaterConsumption.Value, wco.WaterConsumption.UnitCode, wco.WaterConsumption.ObservedAt, x, y, s.source)
+func (s *storage) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
 }
 
-func (s *storage) StoreWeatherObserved(ctx context.Context, wo WeatherObserved) error {
-	var x, y float64 = 0.0, 0.0
-	if wo.Location.Value.Coordinates != nil && len(wo.Location.Value.Coordinates) > 1 {
-		x = wo.Location.Value.Coordinates[0]
-		y = wo.Location.Value.Coordinates[1]
+func (s *storage) Close() {
+	s.pool.Close()
+}
+
+// Store writes all observations in a single round trip and transaction. Rows that already exist are
+// skipped and reported in the result, any other error rolls back the whole batch. Transactions that
+// fail because of transient errors are retried, unless the connection was lost while committing.
+func (s *storage) Store(ctx context.Context, obs []Observation) (result StoreResult, err error) {
+	log := logging.GetFromContext(ctx)
+
+	if len(obs) == 0 {
+		return StoreResult{Duplicates: []bool{}}, nil
 	}
 
+	ctx, span := tracer.Start(ctx, "store-observations", trace.WithAttributes(attribute.Int("observations", len(obs))))
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	opts := insertOptions{schema: s.schema, source: s.source, locationFallback: s.locationFallback}
+
+	batch := &pgx.Batch{}
+	for _, o := range obs {
+		sql, args := o.insert(opts)
+		batch.Queue(sql, args...)
+	}
+
+	log.Debug().Msgf("storing %d observations", len(obs))
+
+	duplicates := make([]bool, len(obs))
+
+	err = s.retrier.do(ctx, "store", func(ctx context.Context) error {
+		return transaction(ctx, s.pool, func(tx pgx.Tx) error {
+			br := tx.SendBatch(ctx, batch)
+
+			for i := range obs {
+				tag, err := br.Exec()
+				if err != nil {
+					br.Close()
+					return &ObservationError{Index: i, Err: err}
+				}
+
+				duplicates[i] = tag.RowsAffected() == 0
+			}
+
+			return br.Close()
+		})
+	})
+	if err != nil {
+		return StoreResult{}, err
+	}
+
+	result.Duplicates = duplicates
+	for _, duplicate := range duplicates {
+		if duplicate {
+			result.Skipped++
+		} else {
+			result.Inserted++
+		}
+	}
+
+	return result, nil
+}
+
+// insert stores the reading along with the consumption and unit code as they were received. The consumption since the
+// previous reading, the flow rate and whether the register has been reset or the meter replaced are computed by the
+// database, see migration 0007_water_consumption_delta.
+func (wco WaterConsumptionObserved) insert(opts insertOptions) (string, []any) {
+	sql := fmt.Sprintf(`INSERT INTO %s.waterConsumptionObserved ("id", "waterConsumption", "unitCode", "observedAt", "location", "source",
+		"alarmStopsLeaks", "alarmTamper", "alarmMetrology", "alarmWaterQuality", "alarmFlowPersistence", "alarmSystem", "alarmInProgress",
+		"moduleTampered", "acquisitionStageFailure", "maxFlow", "minFlow", "persistenceFlowDuration", "validationErrors", "observedBy",
+		"originalWaterConsumption", "originalUnitCode", "createdAt")
+		VALUES ($1, $2, $3, $4, %s, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, current_timestamp) ON CONFLICT DO NOTHING;`, opts.schema, opts.location("waterConsumptionObserved", 5))
+
+	originalValue, originalUnit := wco.received()
+
+	return sql, []any{wco.Id, wco.WaterConsumption.Value, wco.WaterConsumption.UnitCode, wco.WaterConsumption.ObservedAt, wco.Location.geoJSON(), opts.source,
+		wco.AlarmStopsLeaks.value(), wco.AlarmTamper.value(), wco.AlarmMetrology.value(), wco.AlarmWaterQuality.value(), wco.AlarmFlowPersistence.value(),
+		wco.AlarmSystem.value(), wco.AlarmInProgress.value(), wco.ModuleTampered.value(), wco.AcquisitionStageFailure.value(),
+		wco.MaxFlow.value(), wco.MinFlow.value(), wco.PersistenceFlowDuration.value(), wco.flags.validationErrors(),
+		nullIfEmpty(wco.WaterConsumption.ObservedBy.Object), originalValue, originalUnit}
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func (wo WeatherObserved) insert(opts insertOptions) (string, []any) {
+
 	t := wo.Temperature.Value
 	observedAt := ""
 	if wo.Temperature.ObservedAt != "" {
 		observedAt = wo.Temperature.ObservedAt
 	}
 
-	sql := fmt.Sprintf(`INSERT INTO %s.weatherObserved ("id", "temperature", "observedAt", "location", "source", "createdAt") VALUES ($1, $2, $3, ST_MakePoint($4,$5), $6, current_timestamp) ON CONFLICT DO NOTHING;`, s.schema)
+	sql := fmt.Sprintf(`INSERT INTO %s.weatherObserved ("id", "temperature", "observedAt", "location", "source", "validationErrors", "createdAt") VALUES ($1, $2, $3, %s, $5, $6, current_timestamp) ON CONFLICT DO NOTHING;`, opts.schema, opts.location("weatherObserved", 4))
 
-	return s.exec(ctx, sql, wo.Id, t, observedAt, x, y, s.source)
+	return sql, []any{wo.Id, t, observedAt, wo.Location.geoJSON(), opts.source, wo.flags.validationErrors()}
 }
 
-func (s *storage) StoreIndoorEnvironmentObserved(ctx context.Context, ieo IndoorEnvironmentObserved) error {
-	var x, y float64 = 0.0, 0.0
-	if ieo.Location.Value.Coordinates != nil && len(ieo.Location.Value.Coordinates) > 1 {
-		x = ieo.Location.Value.Coordinates[0]
-		y = ieo.Location.Value.Coordinates[1]
-	}
+func (ieo IndoorEnvironmentObserved) insert(opts insertOptions) (string, []any) {
 
 	t := ieo.Temperature.Value
 	h := ieo.Humidity.Value
@@ -95,96 +415,268 @@ func (s *storage) StoreIndoorEnvironmentObserved(ctx context.Context, ieo Indoor
 		observedAt = ieo.Humidity.ObservedAt
 	}
 
-	sql := fmt.Sprintf(`INSERT INTO %s.indoorEnvironmentObserved ("id", "temperature", "humidity", "observedAt", "location", "source", "createdAt") VALUES ($1, $2, $3, $4, ST_MakePoint($5,$6), $7, current_timestamp) ON CONFLICT DO NOTHING;`, s.schema)
+	sql := fmt.Sprintf(`INSERT INTO %s.indoorEnvironmentObserved ("id", "temperature", "humidity", "observedAt", "location", "source", "validationErrors", "createdAt") VALUES ($1, $2, $3, $4, %s, $6, $7, current_timestamp) ON CONFLICT DO NOTHING;`, opts.schema, opts.location("indoorEnvironmentObserved", 5))
 
-	return s.exec(ctx, sql, ieo.Id, t, h, observedAt, x, y, s.source)
+	return sql, []any{ieo.Id, t, h, observedAt, ieo.Location.geoJSON(), opts.source, ieo.flags.validationErrors()}
 }
 
-func (s *storage) exec(ctx context.Context, sql string, arguments ...any) error {
-	log := logging.GetFromContext(ctx)
+// insert stores the dead letter unless the same entity has already been stored for the subscription
+func (dl DeadLetter) insert(opts insertOptions) (string, []any) {
+	sql := fmt.Sprintf(`INSERT INTO %s.deadLetter ("entityId", "entityType", "subscriptionId", "entity", "error", "createdAt") VALUES ($1, $2, $3, $4, $5, current_timestamp) ON CONFLICT ("digest") DO NOTHING;`, opts.schema)
+
+	return sql, []any{dl.EntityId, dl.EntityType, dl.SubscriptionId, string(dl.Entity), dl.Error}
+}
 
-	log.Debug().Msg(sql)
+func (s *storage) DeadLetters(ctx context.Context, offset, limit int) ([]DeadLetter, error) {
+	sql := fmt.Sprintf(`SELECT "id", "entityId", "entityType", "subscriptionId", "entity", "error", "createdAt" FROM %s.deadLetter ORDER BY "id" LIMIT $1 OFFSET $2`, s.schema)
 
-	dbpool, err := pgxpool.New(ctx, s.connUrl)
+	rows, err := s.pool.Query(ctx, sql, limit, offset)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[DeadLetter])
+}
+
+func (s *storage) DeadLetter(ctx context.Context, id int64) (DeadLetter, error) {
+	sql := fmt.Sprintf(`SELECT "id", "entityId", "entityType", "subscriptionId", "entity", "error", "createdAt" FROM %s.deadLetter WHERE "id" = $1`, s.schema)
+
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		return DeadLetter{}, err
 	}
-	defer dbpool.Close()
 
-	_, err = dbpool.Exec(ctx, sql, arguments...)
+	dl, err := pgx.CollectOneRow(rows, pgx.RowToStructByPos[DeadLetter])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DeadLetter{}, ErrNotFound
+	}
+
+	return dl, err
+}
+
+func (s *storage) DeleteDeadLetter(ctx context.Context, id int64) error {
+	sql := fmt.Sprintf(`DELETE FROM %s.deadLetter WHERE "id" = $1`, s.schema)
+
+	tag, err := s.pool.Exec(ctx, sql, id)
 	if err != nil {
 		return err
 	}
 
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
 	return nil
 }
 
-/*
--- TABLE
+// WaterMeters returns the latest reading of every meter, ordered by id
+func (s *storage) WaterMeters(ctx context.Context, offset, limit int) ([]WaterMeter, error) {
+	sql := fmt.Sprintf(`SELECT %s FROM %s."latestWaterConsumptionObserved" ORDER BY "id" LIMIT $1 OFFSET $2`, waterMeterColumns, s.schema)
+
+	rows, err := s.pool.Query(ctx, sql, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, rowToWaterMeter)
+}
+
+func (s *storage) WaterMeter(ctx context.Context, id string) (WaterMeter, error) {
+	sql := fmt.Sprintf(`SELECT %s FROM %s."latestWaterConsumptionObserved" WHERE "id" = $1`, waterMeterColumns, s.schema)
+
+	rows, err := s.pool.Query(ctx, sql, id)
+	if err != nil {
+		return WaterMeter{}, err
+	}
+
+	m, err := pgx.CollectOneRow(rows, rowToWaterMeter)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return WaterMeter{}, ErrNotFound
+	}
+
+	return m, err
+}
+
+// Series calls fn with each point of a series in order as they are read, so that large series do not have to
+// be kept in memory. The query stops at the first error returned by fn.
+func (s *storage) Series(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error {
+	t, ok := seriesTables[strings.ToLower(q.Type)]
+	if !ok {
+		return fmt.Errorf("no series for type %s", q.Type)
+	}
+
+	sql, err := t.sql(s.schema, q.Resolution)
+	if err != nil {
+		return err
+	}
+
+	if q.Location == nil {
+		q.Location = time.UTC
+	}
+
+	args := []any{q.Id, q.From.UTC(), q.To.UTC()}
+	if q.Resolution != ResolutionRaw {
+		args = append(args, q.Location.String())
+	}
+
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]*float64, len(t.measures))
+	dest := make([]any, 0, len(t.measures)+2)
 
-CREATE SCHEMA geodata_vattenmatare;
+	p := SeriesPoint{}
+	dest = append(dest, &p.Time, &p.Readings)
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
 
-CREATE TABLE geodata_vattenmatare.waterConsumptionObserved
-(
-    "id" text COLLATE pg_catalog."default" NOT NULL,
-    "waterConsumption" numeric,
-    "unitCode" text COLLATE pg_catalog."default",
-    "observedAt" timestamp,
-    "source" text,
-    "location" geometry(Geometry, 4326),
-	"createdAt" timestamp,
-    CONSTRAINT pkey_wco PRIMARY KEY("id", "observedAt")
-);
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+
+		point := SeriesPoint{Time: p.Time.In(q.Location), Readings: p.Readings, Values: map[string]*float64{}}
+		for i, m := range t.measures {
+			point.Values[m.name] = values[i]
+		}
+
+		err = fn(point)
+		if err != nil {
+			return err
+		}
+	}
 
-CREATE VIEW geodata_vattenmatare."latestWaterConsumptionObserved"
- AS select distinct on ("id") "id", "waterConsumption", "unitCode", "source", "location", "observedAt"
-from geodata_vattenmatare.waterconsumptionobserved
-order by id, "observedAt" desc;
+	return rows.Err()
+}
 
-ALTER TABLE geodata_vattenmatare."latestWaterConsumptionObserved"
-    OWNER TO postgres;
+// Features calls fn with the latest observation of every entity that matches the query as a GeoJSON feature, in
+// order as they are read
+func (s *storage) Features(ctx context.Context, q FeatureQuery, fn func(Feature) error) error {
+	sql, args := q.sql(s.schema)
 
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f, err := rowToFeature(rows)
+		if err != nil {
+			return err
+		}
+
+		err = fn(f)
+		if err != nil {
+			return err
+		}
+	}
 
+	return rows.Err()
+}
 
-CREATE TABLE geodata_vattenmatare.indoorEnvironmentObserved
-(
-    "id" text COLLATE pg_catalog."default" NOT NULL,
-    "temperature" numeric,
-	"humidity" numeric,
-    "observedAt" timestamp,
-    "source" text,
-    "location" geometry(Geometry, 4326),
-	"createdAt" timestamp,
-    CONSTRAINT pkey_ieo PRIMARY KEY("id", "observedAt")
-);
+// Meters returns the ids of the meters that have been read since the given time, ordered by id
+func (s *storage) Meters(ctx context.Context, since time.Time) ([]string, error) {
+	sql := fmt.Sprintf(`SELECT "id" FROM %s.latestObservation WHERE "table" = 'waterconsumptionobserved' AND "observedAt" >= $1 ORDER BY "id"`, s.schema)
 
-CREATE VIEW geodata_vattenmatare."latestIndoorEnvironmentObserved"
- AS select distinct on ("id") "id", "temperature", "humidity", "source", "location", "observedAt"
-from geodata_vattenmatare.indoorEnvironmentObserved
-order by id, "observedAt" desc;
+	rows, err := s.pool.Query(ctx, sql, since.UTC())
+	if err != nil {
+		return nil, err
+	}
 
-ALTER TABLE geodata_vattenmatare."latestIndoorEnvironmentObserved"
-    OWNER TO postgres;
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
 
+// Readings returns the water consumption readings of a meter observed since the given time, ordered by time
+func (s *storage) Readings(ctx context.Context, id string, since time.Time) ([]Reading, error) {
+	sql := fmt.Sprintf(`SELECT "id", "observedAt", "flowRate", "alarmStopsLeaks", "alarmFlowPersistence", "persistenceFlowDuration"
+		FROM %s.waterConsumptionObserved WHERE "id" = $1 AND "observedAt" >= $2 ORDER BY "observedAt"`, s.schema)
 
+	rows, err := s.pool.Query(ctx, sql, id, since.UTC())
+	if err != nil {
+		return nil, err
+	}
 
-CREATE TABLE geodata_vattenmatare.weatherObserved
-(
-    "id" text COLLATE pg_catalog."default" NOT NULL,
-    "temperature" numeric,
-    "observedAt" timestamp,
-    "source" text,
-    "location" geometry(Geometry, 4326),
-	"createdAt" timestamp,
-    CONSTRAINT pkey_wo PRIMARY KEY("id", "observedAt")
-);
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Reading])
+}
 
-CREATE VIEW geodata_vattenmatare."latestWeatherObserved"
- AS select distinct on ("id") "id", "temperature", "source", "location", "observedAt"
-from geodata_vattenmatare.weatherObserved
-order by id, "observedAt" desc;
+// StoreLeakSuspicions stores the suspicions in a single transaction. A suspicion that overlaps with one that is
+// already stored for the same meter and rule extends it instead of being stored again.
+func (s *storage) StoreLeakSuspicions(ctx context.Context, suspicions []LeakSuspicion) error {
+	sql := fmt.Sprintf(`WITH updated AS (
+			UPDATE %[1]s.leakSuspicion SET "windowStart" = LEAST("windowStart", $4), "windowEnd" = GREATEST("windowEnd", $5),
+				"severity" = $3, "evidence" = $6, "detectedAt" = current_timestamp
+			WHERE "entityId" = $1 AND "rule" = $2 AND "windowStart" <= $5 AND "windowEnd" >= $4
+			RETURNING "id"
+		)
+		INSERT INTO %[1]s.leakSuspicion ("entityId", "rule", "severity", "windowStart", "windowEnd", "evidence", "detectedAt")
+		SELECT $1, $2, $3, $4, $5, $6, current_timestamp WHERE NOT EXISTS (SELECT 1 FROM updated)`, s.schema)
+
+	batch := &pgx.Batch{}
+	for _, ls := range suspicions {
+		batch.Queue(sql, ls.EntityId, ls.Rule, string(ls.Severity), ls.WindowStart.UTC(), ls.WindowEnd.UTC(), ls.Evidence)
+	}
 
-ALTER TABLE geodata_vattenmatare."latestWeatherObserved"
-    OWNER TO postgres;
+	return s.retrier.do(ctx, "store-leak-suspicions", func(ctx context.Context) error {
+		return transaction(ctx, s.pool, func(tx pgx.Tx) error {
+			return tx.SendBatch(ctx, batch).Close()
+		})
+	})
+}
 
-*/
+// UpdateAlarms passes the stored state of the alarms of the meters to update, and stores the alarms that it adds
+// or changes. Updates of the same meter are serialized, so that a change is only seen once by every instance of
+// the service. The update may be called again if the transaction is retried.
+func (s *storage) UpdateAlarms(ctx context.Context, ids []string, update func(state map[AlarmKey]AlarmValue)) error {
+	locks := `SELECT pg_advisory_xact_lock(hashtext('alarm:' || "id")) FROM unnest($1::text[]) AS "id" ORDER BY "id"`
+	query := fmt.Sprintf(`SELECT "id", "alarm", "raised", "observedAt" FROM %s.alarmState WHERE "id" = ANY($1)`, s.schema)
+	upsert := fmt.Sprintf(`INSERT INTO %s.alarmState AS a ("id", "alarm", "raised", "observedAt") VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("id", "alarm") DO UPDATE SET "raised" = EXCLUDED."raised", "observedAt" = EXCLUDED."observedAt"`, s.schema)
+
+	return s.retrier.do(ctx, "update-alarms", func(ctx context.Context) error {
+		return transaction(ctx, s.pool, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, locks, ids)
+			if err != nil {
+				return err
+			}
+
+			rows, err := tx.Query(ctx, query, ids)
+			if err != nil {
+				return err
+			}
+
+			stored := map[AlarmKey]AlarmValue{}
+			var key AlarmKey
+			var value AlarmValue
+
+			_, err = pgx.ForEachRow(rows, []any{&key.Id, &key.Alarm, &value.Raised, &value.ObservedAt}, func() error {
+				stored[key] = value
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			state := maps.Clone(stored)
+			update(state)
+
+			batch := &pgx.Batch{}
+			for k, v := range state {
+				if previous, ok := stored[k]; !ok || previous != v {
+					batch.Queue(upsert, k.Id, k.Alarm, v.Raised, v.ObservedAt.UTC())
+				}
+			}
+
+			if batch.Len() == 0 {
+				return nil
+			}
+
+			return tx.SendBatch(ctx, batch).Close()
+		})
+	})
+}
@@ -6,6 +6,7 @@ package application
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 // Ensure, that StorageMock does implement Storage.
@@ -18,14 +19,47 @@ var _ Storage = &StorageMock{}
 //
 //		// make and configure a mocked Storage
 //		mockedStorage := &StorageMock{
-//			StoreIndoorEnvironmentObservedFunc: func(ctx context.Context, i IndoorEnvironmentObserved) error {
-//				panic("mock out the StoreIndoorEnvironmentObserved method")
+//			CloseFunc: func() {
+//				panic("mock out the Close method")
 //			},
-//			StoreWaterConsumptionObservedFunc: func(ctx context.Context, w WaterConsumptionObserved) error {
-//				panic("mock out the StoreWaterConsumptionObserved method")
+//			DeadLetterFunc: func(ctx context.Context, id int64) (DeadLetter, error) {
+//				panic("mock out the DeadLetter method")
 //			},
-//			StoreWeatherObservedFunc: func(ctx context.Context, w WeatherObserved) error {
-//				panic("mock out the StoreWeatherObserved method")
+//			DeadLettersFunc: func(ctx context.Context, offset int, limit int) ([]DeadLetter, error) {
+//				panic("mock out the DeadLetters method")
+//			},
+//			DeleteDeadLetterFunc: func(ctx context.Context, id int64) error {
+//				panic("mock out the DeleteDeadLetter method")
+//			},
+//			FeaturesFunc: func(ctx context.Context, q FeatureQuery, fn func(Feature) error) error {
+//				panic("mock out the Features method")
+//			},
+//			MetersFunc: func(ctx context.Context, since time.Time) ([]string, error) {
+//				panic("mock out the Meters method")
+//			},
+//			PingFunc: func(ctx context.Context) error {
+//				panic("mock out the Ping method")
+//			},
+//			ReadingsFunc: func(ctx context.Context, id string, since time.Time) ([]Reading, error) {
+//				panic("mock out the Readings method")
+//			},
+//			SeriesFunc: func(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error {
+//				panic("mock out the Series method")
+//			},
+//			StoreFunc: func(ctx context.Context, obs []Observation) (StoreResult, error) {
+//				panic("mock out the Store method")
+//			},
+//			StoreLeakSuspicionsFunc: func(ctx context.Context, suspicions []LeakSuspicion) error {
+//				panic("mock out the StoreLeakSuspicions method")
+//			},
+//			UpdateAlarmsFunc: func(ctx context.Context, ids []string, update func(state map[AlarmKey]AlarmValue)) error {
+//				panic("mock out the UpdateAlarms method")
+//			},
+//			WaterMeterFunc: func(ctx context.Context, id string) (WaterMeter, error) {
+//				panic("mock out the WaterMeter method")
+//			},
+//			WaterMetersFunc: func(ctx context.Context, offset int, limit int) ([]WaterMeter, error) {
+//				panic("mock out the WaterMeters method")
 //			},
 //		}
 //
@@ -34,148 +68,682 @@ var _ Storage = &StorageMock{}
 //
 //	}
 type StorageMock struct {
-	// StoreIndoorEnvironmentObservedFunc mocks the StoreIndoorEnvironmentObserved method.
-	StoreIndoorEnvironmentObservedFunc func(ctx context.Context, i IndoorEnvironmentObserved) error
+	// CloseFunc mocks the Close method.
+	CloseFunc func()
+
+	// DeadLetterFunc mocks the DeadLetter method.
+	DeadLetterFunc func(ctx context.Context, id int64) (DeadLetter, error)
+
+	// DeadLettersFunc mocks the DeadLetters method.
+	DeadLettersFunc func(ctx context.Context, offset int, limit int) ([]DeadLetter, error)
+
+	// DeleteDeadLetterFunc mocks the DeleteDeadLetter method.
+	DeleteDeadLetterFunc func(ctx context.Context, id int64) error
 
-	// StoreWaterConsumptionObservedFunc mocks the StoreWaterConsumptionObserved method.
-	StoreWaterConsumptionObservedFunc func(ctx context.Context, w WaterConsumptionObserved) error
+	// FeaturesFunc mocks the Features method.
+	FeaturesFunc func(ctx context.Context, q FeatureQuery, fn func(Feature) error) error
 
-	// StoreWeatherObservedFunc mocks the StoreWeatherObserved method.
-	StoreWeatherObservedFunc func(ctx context.Context, w WeatherObserved) error
+	// MetersFunc mocks the Meters method.
+	MetersFunc func(ctx context.Context, since time.Time) ([]string, error)
+
+	// PingFunc mocks the Ping method.
+	PingFunc func(ctx context.Context) error
+
+	// ReadingsFunc mocks the Readings method.
+	ReadingsFunc func(ctx context.Context, id string, since time.Time) ([]Reading, error)
+
+	// SeriesFunc mocks the Series method.
+	SeriesFunc func(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error
+
+	// StoreFunc mocks the Store method.
+	StoreFunc func(ctx context.Context, obs []Observation) (StoreResult, error)
+
+	// StoreLeakSuspicionsFunc mocks the StoreLeakSuspicions method.
+	StoreLeakSuspicionsFunc func(ctx context.Context, suspicions []LeakSuspicion) error
+
+	// UpdateAlarmsFunc mocks the UpdateAlarms method.
+	UpdateAlarmsFunc func(ctx context.Context, ids []string, update func(state map[AlarmKey]AlarmValue)) error
+
+	// WaterMeterFunc mocks the WaterMeter method.
+	WaterMeterFunc func(ctx context.Context, id string) (WaterMeter, error)
+
+	// WaterMetersFunc mocks the WaterMeters method.
+	WaterMetersFunc func(ctx context.Context, offset int, limit int) ([]WaterMeter, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
-		// StoreIndoorEnvironmentObserved holds details about calls to the StoreIndoorEnvironmentObserved method.
-		StoreIndoorEnvironmentObserved []struct {
+		// Close holds details about calls to the Close method.
+		Close []struct {
+		}
+		// DeadLetter holds details about calls to the DeadLetter method.
+		DeadLetter []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// ID is the id argument value.
+			ID int64
+		}
+		// DeadLetters holds details about calls to the DeadLetters method.
+		DeadLetters []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Offset is the offset argument value.
+			Offset int
+			// Limit is the limit argument value.
+			Limit int
+		}
+		// DeleteDeadLetter holds details about calls to the DeleteDeadLetter method.
+		DeleteDeadLetter []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// ID is the id argument value.
+			ID int64
+		}
+		// Features holds details about calls to the Features method.
+		Features []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Q is the q argument value.
+			Q FeatureQuery
+			// Fn is the fn argument value.
+			Fn func(Feature) error
+		}
+		// Meters holds details about calls to the Meters method.
+		Meters []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Since is the since argument value.
+			Since time.Time
+		}
+		// Ping holds details about calls to the Ping method.
+		Ping []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+		}
+		// Readings holds details about calls to the Readings method.
+		Readings []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// ID is the id argument value.
+			ID string
+			// Since is the since argument value.
+			Since time.Time
+		}
+		// Series holds details about calls to the Series method.
+		Series []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Q is the q argument value.
+			Q SeriesQuery
+			// Fn is the fn argument value.
+			Fn func(SeriesPoint) error
+		}
+		// Store holds details about calls to the Store method.
+		Store []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Obs is the obs argument value.
+			Obs []Observation
+		}
+		// StoreLeakSuspicions holds details about calls to the StoreLeakSuspicions method.
+		StoreLeakSuspicions []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Suspicions is the suspicions argument value.
+			Suspicions []LeakSuspicion
+		}
+		// UpdateAlarms holds details about calls to the UpdateAlarms method.
+		UpdateAlarms []struct {
 			// Ctx is the ctx argument value.
 			Ctx context.Context
-			// I is the i argument value.
-			I IndoorEnvironmentObserved
+			// Ids is the ids argument value.
+			Ids []string
+			// Update is the update argument value.
+			Update func(state map[AlarmKey]AlarmValue)
 		}
-		// StoreWaterConsumptionObserved holds details about calls to the StoreWaterConsumptionObserved method.
-		StoreWaterConsumptionObserved []struct {
+		// WaterMeter holds details about calls to the WaterMeter method.
+		WaterMeter []struct {
 			// Ctx is the ctx argument value.
 			Ctx context.Context
-			// W is the w argument value.
-			W WaterConsumptionObserved
+			// ID is the id argument value.
+			ID string
 		}
-		// StoreWeatherObserved holds details about calls to the StoreWeatherObserved method.
-		StoreWeatherObserved []struct {
+		// WaterMeters holds details about calls to the WaterMeters method.
+		WaterMeters []struct {
 			// Ctx is the ctx argument value.
 			Ctx context.Context
-			// W is the w argument value.
-			W WeatherObserved
+			// Offset is the offset argument value.
+			Offset int
+			// Limit is the limit argument value.
+			Limit int
 		}
 	}
-	lockStoreIndoorEnvironmentObserved sync.RWMutex
-	lockStoreWaterConsumptionObserved  sync.RWMutex
-	lockStoreWeatherObserved           sync.RWMutex
+	lockClose               sync.RWMutex
+	lockDeadLetter          sync.RWMutex
+	lockDeadLetters         sync.RWMutex
+	lockDeleteDeadLetter    sync.RWMutex
+	lockFeatures            sync.RWMutex
+	lockMeters              sync.RWMutex
+	lockPing                sync.RWMutex
+	lockReadings            sync.RWMutex
+	lockSeries              sync.RWMutex
+	lockStore               sync.RWMutex
+	lockStoreLeakSuspicions sync.RWMutex
+	lockUpdateAlarms        sync.RWMutex
+	lockWaterMeter          sync.RWMutex
+	lockWaterMeters         sync.RWMutex
 }
 
-// StoreIndoorEnvironmentObserved calls StoreIndoorEnvironmentObservedFunc.
-func (mock *StorageMock) StoreIndoorEnvironmentObserved(ctx context.Context, i IndoorEnvironmentObserved) error {
-	if mock.StoreIndoorEnvironmentObservedFunc == nil {
-		panic("StorageMock.StoreIndoorEnvironmentObservedFunc: method is nil but Storage.StoreIndoorEnvironmentObserved was just called")
+// Close calls CloseFunc.
+func (mock *StorageMock) Close() {
+	if mock.CloseFunc == nil {
+		panic("StorageMock.CloseFunc: method is nil but Storage.Close was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockClose.Lock()
+	mock.calls.Close = append(mock.calls.Close, callInfo)
+	mock.lockClose.Unlock()
+	mock.CloseFunc()
+}
+
+// CloseCalls gets all the calls that were made to Close.
+// Check the length with:
+//
+//	len(mockedStorage.CloseCalls())
+func (mock *StorageMock) CloseCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockClose.RLock()
+	calls = mock.calls.Close
+	mock.lockClose.RUnlock()
+	return calls
+}
+
+// DeadLetter calls DeadLetterFunc.
+func (mock *StorageMock) DeadLetter(ctx context.Context, id int64) (DeadLetter, error) {
+	if mock.DeadLetterFunc == nil {
+		panic("StorageMock.DeadLetterFunc: method is nil but Storage.DeadLetter was just called")
 	}
 	callInfo := struct {
 		Ctx context.Context
-		I   IndoorEnvironmentObserved
+		ID  int64
 	}{
 		Ctx: ctx,
-		I:   i,
+		ID:  id,
 	}
-	mock.lockStoreIndoorEnvironmentObserved.Lock()
-	mock.calls.StoreIndoorEnvironmentObserved = append(mock.calls.StoreIndoorEnvironmentObserved, callInfo)
-	mock.lockStoreIndoorEnvironmentObserved.Unlock()
-	return mock.StoreIndoorEnvironmentObservedFunc(ctx, i)
+	mock.lockDeadLetter.Lock()
+	mock.calls.DeadLetter = append(mock.calls.DeadLetter, callInfo)
+	mock.lockDeadLetter.Unlock()
+	return mock.DeadLetterFunc(ctx, id)
 }
 
-// StoreIndoorEnvironmentObservedCalls gets all the calls that were made to StoreIndoorEnvironmentObserved.
+// DeadLetterCalls gets all the calls that were made to DeadLetter.
 // Check the length with:
 //
-//	len(mockedStorage.StoreIndoorEnvironmentObservedCalls())
-func (mock *StorageMock) StoreIndoorEnvironmentObservedCalls() []struct {
+//	len(mockedStorage.DeadLetterCalls())
+func (mock *StorageMock) DeadLetterCalls() []struct {
 	Ctx context.Context
-	I   IndoorEnvironmentObserved
+	ID  int64
 } {
 	var calls []struct {
 		Ctx context.Context
-		I   IndoorEnvironmentObserved
+		ID  int64
+	}
+	mock.lockDeadLetter.RLock()
+	calls = mock.calls.DeadLetter
+	mock.lockDeadLetter.RUnlock()
+	return calls
+}
+
+// DeadLetters calls DeadLettersFunc.
+func (mock *StorageMock) DeadLetters(ctx context.Context, offset int, limit int) ([]DeadLetter, error) {
+	if mock.DeadLettersFunc == nil {
+		panic("StorageMock.DeadLettersFunc: method is nil but Storage.DeadLetters was just called")
+	}
+	callInfo := struct {
+		Ctx    context.Context
+		Offset int
+		Limit  int
+	}{
+		Ctx:    ctx,
+		Offset: offset,
+		Limit:  limit,
+	}
+	mock.lockDeadLetters.Lock()
+	mock.calls.DeadLetters = append(mock.calls.DeadLetters, callInfo)
+	mock.lockDeadLetters.Unlock()
+	return mock.DeadLettersFunc(ctx, offset, limit)
+}
+
+// DeadLettersCalls gets all the calls that were made to DeadLetters.
+// Check the length with:
+//
+//	len(mockedStorage.DeadLettersCalls())
+func (mock *StorageMock) DeadLettersCalls() []struct {
+	Ctx    context.Context
+	Offset int
+	Limit  int
+} {
+	var calls []struct {
+		Ctx    context.Context
+		Offset int
+		Limit  int
 	}
-	mock.lockStoreIndoorEnvironmentObserved.RLock()
-	calls = mock.calls.StoreIndoorEnvironmentObserved
-	mock.lockStoreIndoorEnvironmentObserved.RUnlock()
+	mock.lockDeadLetters.RLock()
+	calls = mock.calls.DeadLetters
+	mock.lockDeadLetters.RUnlock()
 	return calls
 }
 
-// StoreWaterConsumptionObserved calls StoreWaterConsumptionObservedFunc.
-func (mock *StorageMock) StoreWaterConsumptionObserved(ctx context.Context, w WaterConsumptionObserved) error {
-	if mock.StoreWaterConsumptionObservedFunc == nil {
-		panic("StorageMock.StoreWaterConsumptionObservedFunc: method is nil but Storage.StoreWaterConsumptionObserved was just called")
+// DeleteDeadLetter calls DeleteDeadLetterFunc.
+func (mock *StorageMock) DeleteDeadLetter(ctx context.Context, id int64) error {
+	if mock.DeleteDeadLetterFunc == nil {
+		panic("StorageMock.DeleteDeadLetterFunc: method is nil but Storage.DeleteDeadLetter was just called")
 	}
 	callInfo := struct {
 		Ctx context.Context
-		W   WaterConsumptionObserved
+		ID  int64
 	}{
 		Ctx: ctx,
-		W:   w,
+		ID:  id,
 	}
-	mock.lockStoreWaterConsumptionObserved.Lock()
-	mock.calls.StoreWaterConsumptionObserved = append(mock.calls.StoreWaterConsumptionObserved, callInfo)
-	mock.lockStoreWaterConsumptionObserved.Unlock()
-	return mock.StoreWaterConsumptionObservedFunc(ctx, w)
+	mock.lockDeleteDeadLetter.Lock()
+	mock.calls.DeleteDeadLetter = append(mock.calls.DeleteDeadLetter, callInfo)
+	mock.lockDeleteDeadLetter.Unlock()
+	return mock.DeleteDeadLetterFunc(ctx, id)
 }
 
-// StoreWaterConsumptionObservedCalls gets all the calls that were made to StoreWaterConsumptionObserved.
+// DeleteDeadLetterCalls gets all the calls that were made to DeleteDeadLetter.
 // Check the length with:
 //
-//	len(mockedStorage.StoreWaterConsumptionObservedCalls())
-func (mock *StorageMock) StoreWaterConsumptionObservedCalls() []struct {
+//	len(mockedStorage.DeleteDeadLetterCalls())
+func (mock *StorageMock) DeleteDeadLetterCalls() []struct {
 	Ctx context.Context
-	W   WaterConsumptionObserved
+	ID  int64
 } {
 	var calls []struct {
 		Ctx context.Context
-		W   WaterConsumptionObserved
+		ID  int64
+	}
+	mock.lockDeleteDeadLetter.RLock()
+	calls = mock.calls.DeleteDeadLetter
+	mock.lockDeleteDeadLetter.RUnlock()
+	return calls
+}
+
+// Features calls FeaturesFunc.
+func (mock *StorageMock) Features(ctx context.Context, q FeatureQuery, fn func(Feature) error) error {
+	if mock.FeaturesFunc == nil {
+		panic("StorageMock.FeaturesFunc: method is nil but Storage.Features was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		Q   FeatureQuery
+		Fn  func(Feature) error
+	}{
+		Ctx: ctx,
+		Q:   q,
+		Fn:  fn,
+	}
+	mock.lockFeatures.Lock()
+	mock.calls.Features = append(mock.calls.Features, callInfo)
+	mock.lockFeatures.Unlock()
+	return mock.FeaturesFunc(ctx, q, fn)
+}
+
+// FeaturesCalls gets all the calls that were made to Features.
+// Check the length with:
+//
+//	len(mockedStorage.FeaturesCalls())
+func (mock *StorageMock) FeaturesCalls() []struct {
+	Ctx context.Context
+	Q   FeatureQuery
+	Fn  func(Feature) error
+} {
+	var calls []struct {
+		Ctx context.Context
+		Q   FeatureQuery
+		Fn  func(Feature) error
+	}
+	mock.lockFeatures.RLock()
+	calls = mock.calls.Features
+	mock.lockFeatures.RUnlock()
+	return calls
+}
+
+// Meters calls MetersFunc.
+func (mock *StorageMock) Meters(ctx context.Context, since time.Time) ([]string, error) {
+	if mock.MetersFunc == nil {
+		panic("StorageMock.MetersFunc: method is nil but Storage.Meters was just called")
+	}
+	callInfo := struct {
+		Ctx   context.Context
+		Since time.Time
+	}{
+		Ctx:   ctx,
+		Since: since,
+	}
+	mock.lockMeters.Lock()
+	mock.calls.Meters = append(mock.calls.Meters, callInfo)
+	mock.lockMeters.Unlock()
+	return mock.MetersFunc(ctx, since)
+}
+
+// MetersCalls gets all the calls that were made to Meters.
+// Check the length with:
+//
+//	len(mockedStorage.MetersCalls())
+func (mock *StorageMock) MetersCalls() []struct {
+	Ctx   context.Context
+	Since time.Time
+} {
+	var calls []struct {
+		Ctx   context.Context
+		Since time.Time
 	}
-	mock.lockStoreWaterConsumptionObserved.RLock()
-	calls = mock.calls.StoreWaterConsumptionObserved
-	mock.lockStoreWaterConsumptionObserved.RUnlock()
+	mock.lockMeters.RLock()
+	calls = mock.calls.Meters
+	mock.lockMeters.RUnlock()
 	return calls
 }
 
-// StoreWeatherObserved calls StoreWeatherObservedFunc.
-func (mock *StorageMock) StoreWeatherObserved(ctx context.Context, w WeatherObserved) error {
-	if mock.StoreWeatherObservedFunc == nil {
-		panic("StorageMock.StoreWeatherObservedFunc: method is nil but Storage.StoreWeatherObserved was just called")
+// Ping calls PingFunc.
+func (mock *StorageMock) Ping(ctx context.Context) error {
+	if mock.PingFunc == nil {
+		panic("StorageMock.PingFunc: method is nil but Storage.Ping was just called")
 	}
 	callInfo := struct {
 		Ctx context.Context
-		W   WeatherObserved
 	}{
 		Ctx: ctx,
-		W:   w,
 	}
-	mock.lockStoreWeatherObserved.Lock()
-	mock.calls.StoreWeatherObserved = append(mock.calls.StoreWeatherObserved, callInfo)
-	mock.lockStoreWeatherObserved.Unlock()
-	return mock.StoreWeatherObservedFunc(ctx, w)
+	mock.lockPing.Lock()
+	mock.calls.Ping = append(mock.calls.Ping, callInfo)
+	mock.lockPing.Unlock()
+	return mock.PingFunc(ctx)
 }
 
-// StoreWeatherObservedCalls gets all the calls that were made to StoreWeatherObserved.
+// PingCalls gets all the calls that were made to Ping.
 // Check the length with:
 //
-//	len(mockedStorage.StoreWeatherObservedCalls())
-func (mock *StorageMock) StoreWeatherObservedCalls() []struct {
+//	len(mockedStorage.PingCalls())
+func (mock *StorageMock) PingCalls() []struct {
 	Ctx context.Context
-	W   WeatherObserved
 } {
 	var calls []struct {
 		Ctx context.Context
-		W   WeatherObserved
 	}
-	mock.lockStoreWeatherObserved.RLock()
-	calls = mock.calls.StoreWeatherObserved
-	mock.lockStoreWeatherObserved.RUnlock()
+	mock.lockPing.RLock()
+	calls = mock.calls.Ping
+	mock.lockPing.RUnlock()
+	return calls
+}
+
+// Readings calls ReadingsFunc.
+func (mock *StorageMock) Readings(ctx context.Context, id string, since time.Time) ([]Reading, error) {
+	if mock.ReadingsFunc == nil {
+		panic("StorageMock.ReadingsFunc: method is nil but Storage.Readings was just called")
+	}
+	callInfo := struct {
+		Ctx   context.Context
+		ID    string
+		Since time.Time
+	}{
+		Ctx:   ctx,
+		ID:    id,
+		Since: since,
+	}
+	mock.lockReadings.Lock()
+	mock.calls.Readings = append(mock.calls.Readings, callInfo)
+	mock.lockReadings.Unlock()
+	return mock.ReadingsFunc(ctx, id, since)
+}
+
+// ReadingsCalls gets all the calls that were made to Readings.
+// Check the length with:
+//
+//	len(mockedStorage.ReadingsCalls())
+func (mock *StorageMock) ReadingsCalls() []struct {
+	Ctx   context.Context
+	ID    string
+	Since time.Time
+} {
+	var calls []struct {
+		Ctx   context.Context
+		ID    string
+		Since time.Time
+	}
+	mock.lockReadings.RLock()
+	calls = mock.calls.Readings
+	mock.lockReadings.RUnlock()
+	return calls
+}
+
+// Series calls SeriesFunc.
+func (mock *StorageMock) Series(ctx context.Context, q SeriesQuery, fn func(SeriesPoint) error) error {
+	if mock.SeriesFunc == nil {
+		panic("StorageMock.SeriesFunc: method is nil but Storage.Series was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		Q   SeriesQuery
+		Fn  func(SeriesPoint) error
+	}{
+		Ctx: ctx,
+		Q:   q,
+		Fn:  fn,
+	}
+	mock.lockSeries.Lock()
+	mock.calls.Series = append(mock.calls.Series, callInfo)
+	mock.lockSeries.Unlock()
+	return mock.SeriesFunc(ctx, q, fn)
+}
+
+// SeriesCalls gets all the calls that were made to Series.
+// Check the length with:
+//
+//	len(mockedStorage.SeriesCalls())
+func (mock *StorageMock) SeriesCalls() []struct {
+	Ctx context.Context
+	Q   SeriesQuery
+	Fn  func(SeriesPoint) error
+} {
+	var calls []struct {
+		Ctx context.Context
+		Q   SeriesQuery
+		Fn  func(SeriesPoint) error
+	}
+	mock.lockSeries.RLock()
+	calls = mock.calls.Series
+	mock.lockSeries.RUnlock()
+	return calls
+}
+
+// Store calls StoreFunc.
+func (mock *StorageMock) Store(ctx context.Context, obs []Observation) (StoreResult, error) {
+	if mock.StoreFunc == nil {
+		panic("StorageMock.StoreFunc: method is nil but Storage.Store was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		Obs []Observation
+	}{
+		Ctx: ctx,
+		Obs: obs,
+	}
+	mock.lockStore.Lock()
+	mock.calls.Store = append(mock.calls.Store, callInfo)
+	mock.lockStore.Unlock()
+	return mock.StoreFunc(ctx, obs)
+}
+
+// StoreCalls gets all the calls that were made to Store.
+// Check the length with:
+//
+//	len(mockedStorage.StoreCalls())
+func (mock *StorageMock) StoreCalls() []struct {
+	Ctx context.Context
+	Obs []Observation
+} {
+	var calls []struct {
+		Ctx context.Context
+		Obs []Observation
+	}
+	mock.lockStore.RLock()
+	calls = mock.calls.Store
+	mock.lockStore.RUnlock()
+	return calls
+}
+
+// StoreLeakSuspicions calls StoreLeakSuspicionsFunc.
+func (mock *StorageMock) StoreLeakSuspicions(ctx context.Context, suspicions []LeakSuspicion) error {
+	if mock.StoreLeakSuspicionsFunc == nil {
+		panic("StorageMock.StoreLeakSuspicionsFunc: method is nil but Storage.StoreLeakSuspicions was just called")
+	}
+	callInfo := struct {
+		Ctx        context.Context
+		Suspicions []LeakSuspicion
+	}{
+		Ctx:        ctx,
+		Suspicions: suspicions,
+	}
+	mock.lockStoreLeakSuspicions.Lock()
+	mock.calls.StoreLeakSuspicions = append(mock.calls.StoreLeakSuspicions, callInfo)
+	mock.lockStoreLeakSuspicions.Unlock()
+	return mock.StoreLeakSuspicionsFunc(ctx, suspicions)
+}
+
+// StoreLeakSuspicionsCalls gets all the calls that were made to StoreLeakSuspicions.
+// Check the length with:
+//
+//	len(mockedStorage.StoreLeakSuspicionsCalls())
+func (mock *StorageMock) StoreLeakSuspicionsCalls() []struct {
+	Ctx        context.Context
+	Suspicions []LeakSuspicion
+} {
+	var calls []struct {
+		Ctx        context.Context
+		Suspicions []LeakSuspicion
+	}
+	mock.lockStoreLeakSuspicions.RLock()
+	calls = mock.calls.StoreLeakSuspicions
+	mock.lockStoreLeakSuspicions.RUnlock()
+	return calls
+}
+
+// UpdateAlarms calls UpdateAlarmsFunc.
+func (mock *StorageMock) UpdateAlarms(ctx context.Context, ids []string, update func(state map[AlarmKey]AlarmValue)) error {
+	if mock.UpdateAlarmsFunc == nil {
+		panic("StorageMock.UpdateAlarmsFunc: method is nil but Storage.UpdateAlarms was just called")
+	}
+	callInfo := struct {
+		Ctx    context.Context
+		Ids    []string
+		Update func(state map[AlarmKey]AlarmValue)
+	}{
+		Ctx:    ctx,
+		Ids:    ids,
+		Update: update,
+	}
+	mock.lockUpdateAlarms.Lock()
+	mock.calls.UpdateAlarms = append(mock.calls.UpdateAlarms, callInfo)
+	mock.lockUpdateAlarms.Unlock()
+	return mock.UpdateAlarmsFunc(ctx, ids, update)
+}
+
+// UpdateAlarmsCalls gets all the calls that were made to UpdateAlarms.
+// Check the length with:
+//
+//	len(mockedStorage.UpdateAlarmsCalls())
+func (mock *StorageMock) UpdateAlarmsCalls() []struct {
+	Ctx    context.Context
+	Ids    []string
+	Update func(state map[AlarmKey]AlarmValue)
+} {
+	var calls []struct {
+		Ctx    context.Context
+		Ids    []string
+		Update func(state map[AlarmKey]AlarmValue)
+	}
+	mock.lockUpdateAlarms.RLock()
+	calls = mock.calls.UpdateAlarms
+	mock.lockUpdateAlarms.RUnlock()
+	return calls
+}
+
+// WaterMeter calls WaterMeterFunc.
+func (mock *StorageMock) WaterMeter(ctx context.Context, id string) (WaterMeter, error) {
+	if mock.WaterMeterFunc == nil {
+		panic("StorageMock.WaterMeterFunc: method is nil but Storage.WaterMeter was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+		ID  string
+	}{
+		Ctx: ctx,
+		ID:  id,
+	}
+	mock.lockWaterMeter.Lock()
+	mock.calls.WaterMeter = append(mock.calls.WaterMeter, callInfo)
+	mock.lockWaterMeter.Unlock()
+	return mock.WaterMeterFunc(ctx, id)
+}
+
+// WaterMeterCalls gets all the calls that were made to WaterMeter.
+// Check the length with:
+//
+//	len(mockedStorage.WaterMeterCalls())
+func (mock *StorageMock) WaterMeterCalls() []struct {
+	Ctx context.Context
+	ID  string
+} {
+	var calls []struct {
+		Ctx context.Context
+		ID  string
+	}
+	mock.lockWaterMeter.RLock()
+	calls = mock.calls.WaterMeter
+	mock.lockWaterMeter.RUnlock()
+	return calls
+}
+
+// WaterMeters calls WaterMetersFunc.
+func (mock *StorageMock) WaterMeters(ctx context.Context, offset int, limit int) ([]WaterMeter, error) {
+	if mock.WaterMetersFunc == nil {
+		panic("StorageMock.WaterMetersFunc: method is nil but Storage.WaterMeters was just called")
+	}
+	callInfo := struct {
+		Ctx    context.Context
+		Offset int
+		Limit  int
+	}{
+		Ctx:    ctx,
+		Offset: offset,
+		Limit:  limit,
+	}
+	mock.lockWaterMeters.Lock()
+	mock.calls.WaterMeters = append(mock.calls.WaterMeters, callInfo)
+	mock.lockWaterMeters.Unlock()
+	return mock.WaterMetersFunc(ctx, offset, limit)
+}
+
+// WaterMetersCalls gets all the calls that were made to WaterMeters.
+// Check the length with:
+//
+//	len(mockedStorage.WaterMetersCalls())
+func (mock *StorageMock) WaterMetersCalls() []struct {
+	Ctx    context.Context
+	Offset int
+	Limit  int
+} {
+	var calls []struct {
+		Ctx    context.Context
+		Offset int
+		Limit  int
+	}
+	mock.lockWaterMeters.RLock()
+	calls = mock.calls.WaterMeters
+	mock.lockWaterMeters.RUnlock()
 	return calls
 }
@@ -0,0 +1,153 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/rs/zerolog"
+)
+
+func TestLoadStorageConfig(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv("PG_MAX_CONNS", "20")
+	t.Setenv("PG_CONNECT_TIMEOUT", "3s")
+
+	cfg, err := LoadStorageConfig(zerolog.Nop())
+	is.NoErr(err)
+
+	is.Equal(cfg.MaxConns, int32(20))
+	is.Equal(cfg.MinConns, int32(0))
+	is.Equal(cfg.ConnectTimeout, 3*time.Second)
+	is.Equal(cfg.HealthCheckPeriod, time.Minute)
+	is.Equal(cfg.LocationFallback, false)
+	is.Equal(cfg.ProjectedSRID, 0)
+	is.Equal(cfg.Timescale.Enabled, false)
+}
+
+func TestLoadStorageConfigWithInvalidValue(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv("PG_HEALTH_CHECK_PERIOD", "every minute")
+
+	_, err := LoadStorageConfig(zerolog.Nop())
+	is.True(err != nil)
+}
+
+func TestLoadStorageConfigWithProjection(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv("DB_PROJECTED_EPSG", "3007")
+
+	cfg, err := LoadStorageConfig(zerolog.Nop())
+	is.NoErr(err)
+	is.Equal(cfg.ProjectedSRID, 3007)
+
+	t.Setenv("DB_PROJECTED_EPSG", "SWEREF 99 12 00")
+
+	_, err = LoadStorageConfig(zerolog.Nop())
+	is.True(err != nil)
+}
+
+func TestLoadStorageConfigWithTimescale(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv("DB_TIMESCALE_ENABLED", "true")
+	t.Setenv("DB_TIMESCALE_CHUNK_INTERVAL", "24h")
+	t.Setenv("DB_TIMESCALE_RETAIN_FOR", "17520h")
+
+	cfg, err := LoadStorageConfig(zerolog.Nop())
+	is.NoErr(err)
+	is.True(cfg.Timescale.Enabled)
+	is.Equal(cfg.Timescale.ChunkInterval, 24*time.Hour)
+	is.Equal(cfg.Timescale.CompressAfter, time.Duration(0))
+	is.Equal(interval(cfg.Timescale.RetainFor), "63072000 seconds")
+}
+
+func TestThatMissingLocationsAreStoredAsNull(t *testing.T) {
+	is := is.New(t)
+
+	for _, location := range []string{`{}`, `{"value":{"type":"Point","coordinates":[11.9]}}`, `{"value":{"type":"Point","coordinates":[11.9,157.7]}}`} {
+		wo := WeatherObserved{Entity: Entity{Id: "urn:ngsi-ld:WeatherObserved:01"}}
+		is.NoErr(json.Unmarshal([]byte(location), &wo.Location))
+
+		sql, args := wo.insert(insertOptions{schema: "geodata_vattenmatare"})
+		is.True(strings.Contains(sql, "ST_SetSRID(ST_GeomFromGeoJSON($4), 4326)"))
+		is.Equal(args[3], (*string)(nil)) // location
+	}
+}
+
+func TestThatTheLastKnownLocationCanBeUsedAsFallback(t *testing.T) {
+	is := is.New(t)
+
+	wco := WaterConsumptionObserved{Entity: Entity{Id: "urn:ngsi-ld:WaterConsumptionObserved:01"}}
+	wco.Location.Value = Geometry{Type: "Point", Coordinates: json.RawMessage(`[11.9,57.7]`)}
+
+	sql, args := wco.insert(insertOptions{schema: "geodata_vattenmatare", locationFallback: true})
+	is.True(strings.Contains(sql, `COALESCE(ST_SetSRID(ST_GeomFromGeoJSON($5), 4326), (SELECT "location" FROM geodata_vattenmatare.waterConsumptionObserved WHERE "id" = $1`))
+	is.Equal(*args[4].(*string), `{"type":"Point","coordinates":[11.9,57.7]}`)
+}
+
+func TestThatADeadLetterIsStoredOnce(t *testing.T) {
+	is := is.New(t)
+
+	dl := DeadLetter{EntityId: "urn:ngsi-ld:WeatherObserved:01", SubscriptionId: "sub", Entity: json.RawMessage(`{"id":"urn:ngsi-ld:WeatherObserved:01"}`)}
+
+	sql, _ := dl.insert(insertOptions{schema: "geodata_vattenmatare"})
+	is.True(strings.HasSuffix(sql, `ON CONFLICT ("digest") DO NOTHING;`)) // replays and retries should not add it again
+
+	migrations, err := loadMigrations("geodata_vattenmatare")
+	is.NoErr(err)
+	is.True(strings.Contains(migrations[2].sql, `CONSTRAINT deadLetter_digest_key UNIQUE ("digest")`))
+}
+
+func TestSeriesStatements(t *testing.T) {
+	is := is.New(t)
+
+	sql, err := seriesTables["waterconsumptionobserved"].sql("geodata_vattenmatare", ResolutionRaw)
+	is.NoErr(err)
+	is.True(strings.Contains(sql, `SELECT "observedAt" AT TIME ZONE 'UTC', 1, "waterConsumption", "consumptionDelta", "flowRate" FROM geodata_vattenmatare.waterConsumptionObserved`))
+
+	sql, err = seriesTables["weatherobserved"].sql("geodata_vattenmatare", ResolutionDay)
+	is.NoErr(err)
+	is.True(strings.Contains(sql, `date_trunc('day', "observedAt" AT TIME ZONE 'UTC' AT TIME ZONE $4) AT TIME ZONE $4 AS "period", COUNT(*), AVG("temperature")`))
+
+	_, err = seriesTables["weatherobserved"].sql("geodata_vattenmatare", Resolution("week"))
+	is.True(err != nil)
+}
+
+func TestFeatureQueries(t *testing.T) {
+	is := is.New(t)
+
+	q := FeatureQuery{Types: []string{"weatherobserved"}, BBox: []float64{11.8, 57.6, 12.0, 57.8}, Limit: 100}
+	is.NoErr(q.check())
+
+	sql, args := q.sql("geodata_vattenmatare")
+	is.True(strings.Contains(sql, `FROM geodata_vattenmatare."latestWeatherObserved" v WHERE TRUE AND ST_Intersects("location", ST_MakeEnvelope($1, $2, $3, $4, 4326))`))
+	is.Equal(args[4], "WeatherObserved")
+	is.Equal(len(args), 7)
+	is.Equal(args[5], 100) // limit
+
+	_, args = FeatureQuery{Types: []string{"WeatherObserved"}}.sql("geodata_vattenmatare")
+	is.Equal(args[1], nil) // no limit
+
+	// every type is queried unless there are types in the query
+	sql, _ = FeatureQuery{Limit: 100}.sql("geodata_vattenmatare")
+	is.Equal(strings.Count(sql, "UNION ALL"), 2)
+
+	// a single entity observed within an interval
+	from := time.Date(2023, 10, 1, 2, 0, 0, 0, time.FixedZone("CEST", 7200))
+	sql, args = FeatureQuery{Types: []string{"WeatherObserved"}, Id: "urn:ngsi-ld:WeatherObserved:01", ObservedFrom: from}.sql("geodata_vattenmatare")
+	is.True(strings.Contains(sql, `WHERE TRUE AND "id" = $1 AND "observedAt" >= $2`))
+	is.Equal(args[1], time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC))
+
+	is.True(errors.Is(FeatureQuery{ObservedFrom: from, ObservedTo: from.Add(-time.Hour)}.check(), ErrInvalidQuery))
+	is.True(errors.Is(FeatureQuery{Types: []string{"Device"}}.check(), ErrInvalidQuery))
+	is.True(errors.Is(FeatureQuery{BBox: []float64{12.0, 57.6, 11.8, 57.8}}.check(), ErrInvalidQuery))
+	is.True(errors.Is(FeatureQuery{Near: []float64{11.9, 57.7}}.check(), ErrInvalidQuery))
+	is.True(errors.Is(FeatureQuery{Intersects: json.RawMessage(`{"type":"Polygon","coordinates":[[[11.9,57.7],[12.0,57.7]]]}`)}.check(), ErrInvalidQuery))
+}
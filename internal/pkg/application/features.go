@@ -0,0 +1,188 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrInvalidQuery is returned when a query can not be executed as it is
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Feature is an RFC 7946 GeoJSON feature. The properties are every column of the latest observation.
+type Feature struct {
+	Type       string          `json:"type"`
+	Id         string          `json:"id"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties json.RawMessage `json:"properties"`
+}
+
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+func NewFeatureCollection(features []Feature) FeatureCollection {
+	return FeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// FeatureQuery selects the latest observation of every entity of the given types, or of all types if there are
+// none, that matches all of the filters that are set. BBox is given as minimum longitude, minimum latitude,
+// maximum longitude and maximum latitude, Near as longitude and latitude and Distance in metres. ObservedFrom and
+// ObservedTo are inclusive and left open if zero. All matching features are exported if Limit is 0.
+type FeatureQuery struct {
+	Types        []string
+	Id           string
+	ObservedFrom time.Time
+	ObservedTo   time.Time
+	BBox         []float64
+	Near         []float64
+	Distance     float64
+	Intersects   json.RawMessage
+	Offset       int
+	Limit        int
+}
+
+// FeatureTypes are the entity types that can be queried as features. The latest observation of each entity
+// is kept in a view named after the type, such as latestWaterConsumptionObserved.
+var FeatureTypes = []string{"WaterConsumptionObserved", "WeatherObserved", "IndoorEnvironmentObserved"}
+
+// featureType returns the name of a feature type given in any case
+func featureType(t string) (string, bool) {
+	for _, name := range FeatureTypes {
+		if strings.EqualFold(name, t) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// check returns an error wrapping ErrInvalidQuery if the query has an unknown type or an invalid filter
+func (q FeatureQuery) check() error {
+	for _, t := range q.Types {
+		if _, ok := featureType(t); !ok {
+			return fmt.Errorf("%w: unknown type %s", ErrInvalidQuery, t)
+		}
+	}
+
+	if !q.ObservedFrom.IsZero() && !q.ObservedTo.IsZero() && q.ObservedFrom.After(q.ObservedTo) {
+		return fmt.Errorf("%w: the start of the interval is after its end", ErrInvalidQuery)
+	}
+
+	if q.BBox != nil {
+		if len(q.BBox) != 4 || q.BBox[0] > q.BBox[2] || q.BBox[1] > q.BBox[3] {
+			return fmt.Errorf("%w: bbox must be minimum longitude, minimum latitude, maximum longitude and maximum latitude", ErrInvalidQuery)
+		}
+		if err := checkPositions(q.BBox[0:2], q.BBox[2:4]); err != nil {
+			return fmt.Errorf("%w: bbox %s", ErrInvalidQuery, err.Error())
+		}
+	}
+
+	if q.Near != nil {
+		if len(q.Near) != 2 || q.Distance <= 0 {
+			return fmt.Errorf("%w: near must be a longitude and latitude along with a positive distance", ErrInvalidQuery)
+		}
+		if err := checkPositions(q.Near); err != nil {
+			return fmt.Errorf("%w: near %s", ErrInvalidQuery, err.Error())
+		}
+	}
+
+	if q.Intersects != nil {
+		g := Geometry{}
+		if err := json.Unmarshal(q.Intersects, &g); err != nil || g.empty() {
+			return fmt.Errorf("%w: intersects must be a GeoJSON geometry", ErrInvalidQuery)
+		}
+		if err := g.check(); err != nil {
+			return fmt.Errorf("%w: intersects %s", ErrInvalidQuery, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// sql returns the statement and arguments for the query. The latest observations of each type are combined so
+// that paging applies to all of them, ordered by type and id.
+func (q FeatureQuery) sql(schema string) (string, []any) {
+	types := q.Types
+	if len(types) == 0 {
+		types = FeatureTypes
+	}
+
+	args := []any{}
+	param := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	filters := []string{"TRUE"}
+
+	if q.Id != "" {
+		filters = append(filters, fmt.Sprintf(`"id" = %s`, param(q.Id)))
+	}
+
+	if !q.ObservedFrom.IsZero() {
+		filters = append(filters, fmt.Sprintf(`"observedAt" >= %s`, param(q.ObservedFrom.UTC())))
+	}
+
+	if !q.ObservedTo.IsZero() {
+		filters = append(filters, fmt.Sprintf(`"observedAt" <= %s`, param(q.ObservedTo.UTC())))
+	}
+
+	if q.BBox != nil {
+		filters = append(filters, fmt.Sprintf(`ST_Intersects("location", ST_MakeEnvelope(%s, %s, %s, %s, 4326))`,
+			param(q.BBox[0]), param(q.BBox[1]), param(q.BBox[2]), param(q.BBox[3])))
+	}
+
+	if q.Near != nil {
+		filters = append(filters, fmt.Sprintf(`ST_DWithin("location"::geography, ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography, %s)`,
+			param(q.Near[0]), param(q.Near[1]), param(q.Distance)))
+	}
+
+	if q.Intersects != nil {
+		filters = append(filters, fmt.Sprintf(`ST_Intersects("location", ST_SetSRID(ST_GeomFromGeoJSON(%s), 4326))`, param(string(q.Intersects))))
+	}
+
+	where := strings.Join(filters, " AND ")
+
+	selects := make([]string, 0, len(types))
+	for _, t := range types {
+		name, _ := featureType(t)
+		selects = append(selects, fmt.Sprintf(`SELECT %[1]s::text AS "type", "id", ST_AsGeoJSON("location") AS "geometry",
+			(jsonb_build_object('type', %[1]s::text) || (to_jsonb(v) - 'location' - 'locationProjected'))::text AS "properties"
+			FROM %[2]s."latest%[3]s" v WHERE %[4]s`, param(name), schema, name, where))
+	}
+
+	var limit any
+	if q.Limit > 0 {
+		limit = q.Limit
+	}
+
+	sql := fmt.Sprintf(`%s ORDER BY "type", "id" LIMIT %s OFFSET %s`, strings.Join(selects, " UNION ALL "), param(limit), param(q.Offset))
+
+	return sql, args
+}
+
+func rowToFeature(row pgx.CollectableRow) (Feature, error) {
+	var entityType string
+	var geometry *string
+	var properties string
+
+	f := Feature{Type: "Feature"}
+
+	err := row.Scan(&entityType, &f.Id, &geometry, &properties)
+	if err != nil {
+		return f, err
+	}
+
+	f.Geometry = json.RawMessage("null")
+	if geometry != nil {
+		f.Geometry = json.RawMessage(*geometry)
+	}
+	f.Properties = json.RawMessage(properties)
+
+	return f, nil
+}
@@ -0,0 +1,166 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// GeoProperty is the location of an entity
+type GeoProperty struct {
+	Type  string   `json:"Type"`
+	Value Geometry `json:"value"`
+}
+
+// Geometry is a GeoJSON geometry. The coordinates are kept as they are received since their structure
+// depends on the type of geometry.
+type Geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates,omitempty"`
+	Geometries  []Geometry      `json:"geometries,omitempty"`
+}
+
+func (g Geometry) empty() bool {
+	return g.Type == "" && len(g.Coordinates) == 0 && len(g.Geometries) == 0
+}
+
+// check returns an error if the geometry is not valid GeoJSON or has a position outside of WGS84
+func (g Geometry) check() error {
+	switch g.Type {
+	case "Point":
+		var p []float64
+		if err := g.unmarshal(&p); err != nil {
+			return err
+		}
+		return checkPositions(p)
+	case "MultiPoint":
+		var points [][]float64
+		if err := g.unmarshal(&points); err != nil {
+			return err
+		}
+		return checkPositions(points...)
+	case "LineString":
+		var line [][]float64
+		if err := g.unmarshal(&line); err != nil {
+			return err
+		}
+		return checkLine(line)
+	case "MultiLineString":
+		var lines [][][]float64
+		if err := g.unmarshal(&lines); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if err := checkLine(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Polygon":
+		var rings [][][]float64
+		if err := g.unmarshal(&rings); err != nil {
+			return err
+		}
+		return checkPolygon(rings)
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := g.unmarshal(&polygons); err != nil {
+			return err
+		}
+		for _, rings := range polygons {
+			if err := checkPolygon(rings); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "GeometryCollection":
+		for _, c := range g.Geometries {
+			if err := c.check(); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+}
+
+func (g Geometry) unmarshal(v any) error {
+	err := json.Unmarshal(g.Coordinates, v)
+	if err != nil {
+		return fmt.Errorf("invalid coordinates for a %s", g.Type)
+	}
+	return nil
+}
+
+func checkPositions(positions ...[]float64) error {
+	for _, p := range positions {
+		if len(p) < 2 || len(p) > 3 {
+			return fmt.Errorf("expected 2 or 3 coordinates, got %d", len(p))
+		}
+		if p[0] < -180 || p[0] > 180 || p[1] < -90 || p[1] > 90 {
+			return fmt.Errorf("%g, %g is outside of WGS84", p[0], p[1])
+		}
+	}
+	return nil
+}
+
+func checkLine(line [][]float64) error {
+	if len(line) < 2 {
+		return fmt.Errorf("a line needs at least 2 positions, got %d", len(line))
+	}
+	return checkPositions(line...)
+}
+
+func checkPolygon(rings [][][]float64) error {
+	if len(rings) == 0 {
+		return fmt.Errorf("a polygon needs at least one ring")
+	}
+
+	for _, ring := range rings {
+		if len(ring) < 4 {
+			return fmt.Errorf("a polygon ring needs at least 4 positions, got %d", len(ring))
+		}
+
+		first, last := ring[0], ring[len(ring)-1]
+		if len(first) < 2 || len(last) < 2 || first[0] != last[0] || first[1] != last[1] {
+			return fmt.Errorf("a polygon ring must be closed")
+		}
+
+		if err := checkPositions(ring...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// swapped returns the point with longitude and latitude switched, if that makes it a valid location
+func (g Geometry) swapped() (Geometry, bool) {
+	var p []float64
+	if g.Type != "Point" || json.Unmarshal(g.Coordinates, &p) != nil || len(p) < 2 {
+		return g, false
+	}
+
+	p[0], p[1] = p[1], p[0]
+	if checkPositions(p) != nil {
+		return g, false
+	}
+
+	g.Coordinates, _ = json.Marshal(p)
+	return g, true
+}
+
+// geoJSON returns the location as GeoJSON, or nil if it is missing or invalid
+func (p GeoProperty) geoJSON() *string {
+	if p.Value.empty() || p.Value.check() != nil {
+		return nil
+	}
+
+	b, err := json.Marshal(p.Value)
+	if err != nil {
+		return nil
+	}
+
+	s := string(b)
+	return &s
+}
@@ -0,0 +1,55 @@
+package application
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatGeoJSONGeometriesAreStored(t *testing.T) {
+	is := is.New(t)
+
+	geometries := map[string]string{
+		"Point":      `{"type":"Point","coordinates":[11.974560,57.708870]}`,
+		"MultiPoint": `{"type":"MultiPoint","coordinates":[[11.97456,57.70887],[11.96,57.69]]}`,
+		"LineString": `{"type":"LineString","coordinates":[[11.97456,57.70887],[11.96,57.69],[11.95,57.7]]}`,
+		"Polygon":    `{"type":"Polygon","coordinates":[[[11.9,57.7],[12.0,57.7],[12.0,57.8],[11.9,57.8],[11.9,57.7]]]}`,
+	}
+
+	for geometryType, geometry := range geometries {
+		wco := WaterConsumptionObserved{}
+		is.NoErr(json.Unmarshal([]byte(`{"id":"urn:ngsi-ld:WaterConsumptionObserved:01","location":{"type":"GeoProperty","value":`+geometry+`}}`), &wco))
+		is.Equal(wco.Location.Value.Type, geometryType)
+		is.NoErr(wco.Location.Value.check())
+
+		_, args := wco.insert(insertOptions{schema: "geodata_vattenmatare"})
+		stored := args[4].(*string)
+		is.True(stored != nil) // location should be stored
+
+		g := Geometry{}
+		is.NoErr(json.Unmarshal([]byte(*stored), &g))
+		is.Equal(g.Type, geometryType)
+		is.Equal(string(g.Coordinates), string(wco.Location.Value.Coordinates))
+	}
+}
+
+func TestThatInvalidGeometriesAreDetected(t *testing.T) {
+	is := is.New(t)
+
+	geometries := []string{
+		`{"type":"Point","coordinates":[11.9]}`,
+		`{"type":"MultiPoint","coordinates":[[11.9,57.7],[11.9,157.7]]}`,
+		`{"type":"LineString","coordinates":[[11.9,57.7]]}`,
+		`{"type":"Polygon","coordinates":[[[11.9,57.7],[12.0,57.7],[12.0,57.8],[11.9,57.8]]]}`,
+		`{"type":"Polygon","coordinates":[[11.9,57.7]]}`,
+		`{"type":"Circle","coordinates":[11.9,57.7]}`,
+	}
+
+	for _, geometry := range geometries {
+		g := Geometry{}
+		is.NoErr(json.Unmarshal([]byte(geometry), &g))
+		is.True(g.check() != nil) // geometry should be invalid
+		is.Equal(GeoProperty{Value: g}.geoJSON(), nil)
+	}
+}
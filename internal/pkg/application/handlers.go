@@ -0,0 +1,7 @@
+package application
+
+func init() {
+	RegisterEntityHandler("WaterConsumptionObserved", NewEntityHandler[WaterConsumptionObserved]())
+	RegisterEntityHandler("IndoorEnvironmentObserved", NewEntityHandler[IndoorEnvironmentObserved]())
+	RegisterEntityHandler("WeatherObserved", NewEntityHandler[WeatherObserved]())
+}
@@ -0,0 +1,388 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Leak detection rules
+const (
+	RuleNightFlow       = "nightFlow"
+	RuleStepChange      = "stepChange"
+	RuleFlowPersistence = "flowPersistence"
+	RuleMeterAlarm      = "meterAlarm"
+)
+
+type Severity string
+
+const (
+	SeverityLow    Severity = "low"
+	SeverityMedium Severity = "medium"
+	SeverityHigh   Severity = "high"
+)
+
+// Reading is a stored water consumption observation as used by the leak detection. FlowRate is the average
+// flow per hour since the previous reading of the same meter.
+type Reading struct {
+	Id                      string
+	ObservedAt              time.Time
+	FlowRate                *float64
+	AlarmStopsLeaks         *bool
+	AlarmFlowPersistence    *string
+	PersistenceFlowDuration *string
+}
+
+// LeakSuspicion is a period during which the readings of a meter indicate a leak
+type LeakSuspicion struct {
+	Id          int64     `json:"id"`
+	EntityId    string    `json:"entityId"`
+	Rule        string    `json:"rule"`
+	Severity    Severity  `json:"severity"`
+	WindowStart time.Time `json:"windowStart"`
+	WindowEnd   time.Time `json:"windowEnd"`
+	Evidence    string    `json:"evidence"`
+	DetectedAt  time.Time `json:"detectedAt"`
+}
+
+type leakDetector struct {
+	storage    Storage
+	cfg        LeakDetectionConfig
+	suspicions metric.Int64Counter
+}
+
+func newLeakDetector(s Storage, cfg LeakDetectionConfig) *leakDetector {
+	d := &leakDetector{storage: s, cfg: cfg}
+	d.suspicions, _ = meter.Int64Counter("leak.suspicions", metric.WithDescription("number of leak suspicions found in stored consumption series"))
+	return d
+}
+
+// detectLeaks periodically analyses the consumption series of every meter
+func (a *app) detectLeaks() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.leaks.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), a.leaks.cfg.Interval)
+		err := a.leaks.run(ctx, time.Now())
+		cancel()
+
+		if err != nil {
+			log := logging.GetFromContext(ctx)
+			log.Error().Err(err).Msg("leak detection failed")
+		}
+	}
+}
+
+// run analyses the readings within the configured window before now and stores what is found. The readings are
+// read one meter at a time, so that only the series of one meter is kept in memory. Suspicions that overlap with
+// one that is already stored for the same meter and rule are merged with it.
+func (d *leakDetector) run(ctx context.Context, now time.Time) error {
+	log := logging.GetFromContext(ctx)
+
+	since := now.Add(-d.cfg.Window)
+
+	ids, err := d.storage.Meters(ctx, since)
+	if err != nil {
+		return fmt.Errorf("failed to read meters: %w", err)
+	}
+
+	found, read := 0, 0
+
+	for _, id := range ids {
+		readings, err := d.storage.Readings(ctx, id, since)
+		if err != nil {
+			return fmt.Errorf("failed to read consumption series of %s: %w", id, err)
+		}
+
+		suspicions := d.analyze(readings)
+
+		for _, s := range suspicions {
+			d.suspicions.Add(ctx, 1, metric.WithAttributes(attribute.String("rule", s.Rule), attribute.String("severity", string(s.Severity))))
+		}
+
+		if len(suspicions) > 0 {
+			err = d.storage.StoreLeakSuspicions(ctx, suspicions)
+			if err != nil {
+				return fmt.Errorf("failed to store %d leak suspicions: %w", len(suspicions), err)
+			}
+		}
+
+		found += len(suspicions)
+		read += len(readings)
+	}
+
+	log.Info().Msgf("leak detection found %d suspicions in %d readings of %d meters", found, read, len(ids))
+
+	return nil
+}
+
+// analyze applies every rule to the series of each meter. Readings are expected to be ordered by id and time.
+func (d *leakDetector) analyze(readings []Reading) []LeakSuspicion {
+	suspicions := []LeakSuspicion{}
+
+	for start := 0; start < len(readings); {
+		end := start
+		for end < len(readings) && readings[end].Id == readings[start].Id {
+			end++
+		}
+
+		series := readings[start:end]
+		suspicions = append(suspicions, d.nightFlow(series)...)
+		suspicions = append(suspicions, d.stepChange(series)...)
+		suspicions = append(suspicions, d.flowPersistence(series)...)
+		suspicions = append(suspicions, d.meterAlarms(series)...)
+
+		start = end
+	}
+
+	return suspicions
+}
+
+// severity grades how far a measure is above the level where it starts to indicate a leak
+func severity(ratio float64) Severity {
+	switch {
+	case ratio >= 10:
+		return SeverityHigh
+	case ratio >= 3:
+		return SeverityMedium
+	default:
+		return SeverityLow
+	}
+}
+
+func (d *leakDetector) atNight(t time.Time) bool {
+	h := t.In(d.cfg.Location).Hour()
+	if d.cfg.NightStart < d.cfg.NightEnd {
+		return h >= d.cfg.NightStart && h < d.cfg.NightEnd
+	}
+	return h >= d.cfg.NightStart || h < d.cfg.NightEnd
+}
+
+// nightFlow finds nights during which the flow never dropped below the minimum flow. Since hardly any water is
+// used at night, a flow that does not stop is likely to be a leak.
+func (d *leakDetector) nightFlow(series []Reading) []LeakSuspicion {
+	nights := map[string][]Reading{}
+	keys := []string{}
+
+	for _, r := range series {
+		if r.FlowRate == nil || !d.atNight(r.ObservedAt) {
+			continue
+		}
+
+		// a night that passes midnight belongs to the day it started
+		t := r.ObservedAt.In(d.cfg.Location)
+		if d.cfg.NightStart > d.cfg.NightEnd {
+			t = t.Add(-time.Duration(d.cfg.NightEnd) * time.Hour)
+		}
+
+		key := t.Format(time.DateOnly)
+		if _, ok := nights[key]; !ok {
+			keys = append(keys, key)
+		}
+		nights[key] = append(nights[key], r)
+	}
+
+	suspicions := []LeakSuspicion{}
+
+	for _, key := range keys {
+		night := nights[key]
+		if len(night) < 2 {
+			continue
+		}
+
+		lowest := math.Inf(1)
+		for _, r := range night {
+			lowest = math.Min(lowest, *r.FlowRate)
+		}
+
+		if lowest < d.cfg.MinFlow {
+			continue
+		}
+
+		suspicions = append(suspicions, LeakSuspicion{
+			EntityId:    night[0].Id,
+			Rule:        RuleNightFlow,
+			Severity:    severity(lowest / d.cfg.MinFlow),
+			WindowStart: night[0].ObservedAt,
+			WindowEnd:   night[len(night)-1].ObservedAt,
+			Evidence:    fmt.Sprintf("the flow was at least %.2f per hour in %d readings during the night", lowest, len(night)),
+		})
+	}
+
+	return suspicions
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// stepChange compares the median flow during the last day with the median flow before it. A sudden and lasting
+// increase is typical for a burst pipe or a fixture that does not close.
+func (d *leakDetector) stepChange(series []Reading) []LeakSuspicion {
+	if len(series) == 0 {
+		return nil
+	}
+
+	last := series[len(series)-1].ObservedAt
+	since := last.Add(-24 * time.Hour)
+
+	baseline, recent := []float64{}, []float64{}
+	var windowStart time.Time
+
+	for _, r := range series {
+		if r.FlowRate == nil {
+			continue
+		}
+
+		if r.ObservedAt.After(since) {
+			if len(recent) == 0 {
+				windowStart = r.ObservedAt
+			}
+			recent = append(recent, *r.FlowRate)
+		} else {
+			baseline = append(baseline, *r.FlowRate)
+		}
+	}
+
+	if len(baseline) < 3 || len(recent) < 2 {
+		return nil
+	}
+
+	before, after := median(baseline), median(recent)
+	if after-before < d.cfg.MinFlow || after < d.cfg.StepFactor*before {
+		return nil
+	}
+
+	return []LeakSuspicion{{
+		EntityId:    series[0].Id,
+		Rule:        RuleStepChange,
+		Severity:    severity((after - before) / d.cfg.MinFlow),
+		WindowStart: windowStart,
+		WindowEnd:   last,
+		Evidence:    fmt.Sprintf("the median flow increased from %.2f to %.2f per hour", before, after),
+	}}
+}
+
+// flowPersistence finds periods of at least the configured duration during which water flowed continuously
+func (d *leakDetector) flowPersistence(series []Reading) []LeakSuspicion {
+	suspicions := []LeakSuspicion{}
+
+	var run []Reading
+
+	flush := func() {
+		if len(run) < 2 {
+			run = nil
+			return
+		}
+
+		duration := run[len(run)-1].ObservedAt.Sub(run[0].ObservedAt)
+		if duration >= d.cfg.Persistence {
+			sev := SeverityLow
+			switch {
+			case duration >= 3*d.cfg.Persistence:
+				sev = SeverityHigh
+			case duration >= 2*d.cfg.Persistence:
+				sev = SeverityMedium
+			}
+
+			suspicions = append(suspicions, LeakSuspicion{
+				EntityId:    run[0].Id,
+				Rule:        RuleFlowPersistence,
+				Severity:    sev,
+				WindowStart: run[0].ObservedAt,
+				WindowEnd:   run[len(run)-1].ObservedAt,
+				Evidence:    fmt.Sprintf("water flowed without interruption for %s in %d readings", duration.Round(time.Minute), len(run)),
+			})
+		}
+
+		run = nil
+	}
+
+	for _, r := range series {
+		if r.FlowRate != nil && *r.FlowRate > 0 {
+			run = append(run, r)
+		} else if r.FlowRate != nil {
+			flush()
+		}
+	}
+	flush()
+
+	return suspicions
+}
+
+func flowPersistenceReported(r Reading) bool {
+	if r.AlarmFlowPersistence == nil {
+		return false
+	}
+	v := strings.TrimSpace(*r.AlarmFlowPersistence)
+	return v != "" && !strings.EqualFold(v, "Nothing to report")
+}
+
+// meterAlarms reports the periods during which the meter itself signalled a leak or persistent flow
+func (d *leakDetector) meterAlarms(series []Reading) []LeakSuspicion {
+	suspicions := []LeakSuspicion{}
+
+	var current *LeakSuspicion
+
+	for _, r := range series {
+		leak := r.AlarmStopsLeaks != nil && *r.AlarmStopsLeaks
+		persistence := flowPersistenceReported(r)
+
+		if !leak && !persistence {
+			if current != nil {
+				suspicions = append(suspicions, *current)
+				current = nil
+			}
+			continue
+		}
+
+		if current == nil {
+			current = &LeakSuspicion{EntityId: r.Id, Rule: RuleMeterAlarm, Severity: SeverityMedium, WindowStart: r.ObservedAt}
+		}
+
+		current.WindowEnd = r.ObservedAt
+
+		evidence := []string{}
+		if leak {
+			current.Severity = SeverityHigh
+			evidence = append(evidence, "the meter reports a leak")
+		}
+		if persistence {
+			e := fmt.Sprintf("the meter reports flow persistence %q", *r.AlarmFlowPersistence)
+			if r.PersistenceFlowDuration != nil {
+				e += fmt.Sprintf(" for %s", *r.PersistenceFlowDuration)
+			}
+			evidence = append(evidence, e)
+		}
+		current.Evidence = strings.Join(evidence, ", ")
+	}
+
+	if current != nil {
+		suspicions = append(suspicions, *current)
+	}
+
+	return suspicions
+}
@@ -0,0 +1,151 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatContinuousNightFlowIsSuspected(t *testing.T) {
+	is, d := setupLeakTest(t)
+
+	// two days of hourly readings with a constant flow of 5 per hour, except for the second night
+	flows := make([]float64, 48)
+	for i := range flows {
+		flows[i] = 5
+	}
+	flows[24+3] = 0
+
+	suspicions := d.nightFlow(hourly("urn:ngsi-ld:WaterConsumptionObserved:01", flows...))
+
+	is.Equal(len(suspicions), 1)
+	is.Equal(suspicions[0].Rule, RuleNightFlow)
+	is.Equal(suspicions[0].Severity, SeverityMedium)
+	is.Equal(suspicions[0].WindowStart.In(d.cfg.Location).Hour(), 2)
+	is.Equal(suspicions[0].WindowEnd.In(d.cfg.Location).Hour(), 4)
+}
+
+func TestThatAStepChangeIsSuspected(t *testing.T) {
+	is, d := setupLeakTest(t)
+
+	flows := make([]float64, 72)
+	for i := range flows {
+		flows[i] = 0.5
+		if i >= 48 {
+			flows[i] = 20
+		}
+	}
+
+	suspicions := d.stepChange(hourly("urn:ngsi-ld:WaterConsumptionObserved:01", flows...))
+
+	is.Equal(len(suspicions), 1)
+	is.Equal(suspicions[0].Severity, SeverityHigh)
+	is.Equal(suspicions[0].Evidence, "the median flow increased from 0.50 to 20.00 per hour")
+
+	// the same flow all the time is not a step change
+	is.Equal(len(d.stepChange(hourly("urn:ngsi-ld:WaterConsumptionObserved:01", flows[48:]...))), 0)
+}
+
+func TestThatPersistentFlowIsSuspected(t *testing.T) {
+	is, d := setupLeakTest(t)
+
+	flows := make([]float64, 40)
+	for i := range flows {
+		flows[i] = 0.2
+	}
+	flows[5] = 0
+
+	suspicions := d.flowPersistence(hourly("urn:ngsi-ld:WaterConsumptionObserved:01", flows...))
+
+	is.Equal(len(suspicions), 1) // only the readings after the interruption span long enough
+	is.Equal(suspicions[0].Severity, SeverityLow)
+	is.Equal(suspicions[0].WindowEnd.Sub(suspicions[0].WindowStart), 33*time.Hour)
+}
+
+func TestThatMeterAlarmsAreSuspected(t *testing.T) {
+	is, d := setupLeakTest(t)
+
+	series := hourly("urn:ngsi-ld:WaterConsumptionObserved:01", 1, 1, 1, 1)
+	leak, nothing, persistence, duration := true, "Nothing to report", "Alarm", "3h < 6h"
+	series[0].AlarmFlowPersistence = &nothing
+	series[1].AlarmStopsLeaks = &leak
+	series[2].AlarmFlowPersistence = &persistence
+	series[2].PersistenceFlowDuration = &duration
+
+	suspicions := d.meterAlarms(series)
+
+	is.Equal(len(suspicions), 1)
+	is.Equal(suspicions[0].Severity, SeverityHigh)
+	is.Equal(suspicions[0].WindowStart, series[1].ObservedAt)
+	is.Equal(suspicions[0].WindowEnd, series[2].ObservedAt)
+	is.Equal(suspicions[0].Evidence, `the meter reports flow persistence "Alarm" for 3h < 6h`)
+}
+
+func TestThatSuspicionsAreStored(t *testing.T) {
+	is, d := setupLeakTest(t)
+
+	now := time.Date(2023, 10, 3, 0, 0, 0, 0, time.UTC)
+	readings := map[string][]Reading{
+		"urn:ngsi-ld:WaterConsumptionObserved:01": hourly("urn:ngsi-ld:WaterConsumptionObserved:01", 0, 0, 0),
+		"urn:ngsi-ld:WaterConsumptionObserved:02": hourly("urn:ngsi-ld:WaterConsumptionObserved:02", 3, 3, 3, 3, 3, 3),
+	}
+
+	s := &StorageMock{
+		MetersFunc: func(ctx context.Context, since time.Time) ([]string, error) {
+			return []string{"urn:ngsi-ld:WaterConsumptionObserved:01", "urn:ngsi-ld:WaterConsumptionObserved:02"}, nil
+		},
+		ReadingsFunc: func(ctx context.Context, id string, since time.Time) ([]Reading, error) {
+			return readings[id], nil
+		},
+		StoreLeakSuspicionsFunc: func(ctx context.Context, suspicions []LeakSuspicion) error {
+			return nil
+		},
+	}
+	d.storage = s
+
+	is.NoErr(d.run(context.Background(), now))
+
+	is.Equal(s.MetersCalls()[0].Since, now.Add(-d.cfg.Window))
+	is.Equal(len(s.ReadingsCalls()), 2) // one series at a time
+	is.Equal(s.ReadingsCalls()[1].ID, "urn:ngsi-ld:WaterConsumptionObserved:02")
+	is.Equal(s.ReadingsCalls()[1].Since, now.Add(-d.cfg.Window))
+	is.Equal(len(s.StoreLeakSuspicionsCalls()), 1)
+
+	stored := s.StoreLeakSuspicionsCalls()[0].Suspicions
+	is.Equal(len(stored), 1)
+	is.Equal(stored[0].EntityId, "urn:ngsi-ld:WaterConsumptionObserved:02")
+	is.Equal(stored[0].Rule, RuleNightFlow)
+}
+
+// hourly returns readings with the given flows, one per hour starting at midnight the first of October 2023 in Stockholm
+func hourly(id string, flows ...float64) []Reading {
+	start := time.Date(2023, 9, 30, 22, 0, 0, 0, time.UTC)
+
+	readings := make([]Reading, 0, len(flows))
+	for i := range flows {
+		readings = append(readings, Reading{Id: id, ObservedAt: start.Add(time.Duration(i) * time.Hour), FlowRate: &flows[i]})
+	}
+
+	return readings
+}
+
+func setupLeakTest(t *testing.T) (*is.I, *leakDetector) {
+	is := is.New(t)
+
+	location, err := time.LoadLocation("Europe/Stockholm")
+	is.NoErr(err)
+
+	d := newLeakDetector(nil, LeakDetectionConfig{
+		Window:      168 * time.Hour,
+		Location:    location,
+		NightStart:  2,
+		NightEnd:    5,
+		MinFlow:     1,
+		StepFactor:  3,
+		Persistence: 24 * time.Hour,
+	})
+
+	return is, d
+}
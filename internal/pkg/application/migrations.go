@@ -0,0 +1,216 @@
+package application
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migration files are named <version>_<name>.sql and may refer to the configured schema as ${schema}
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	sql       string
+}
+
+func loadMigrations(schema string) ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(files))
+
+	for _, f := range files {
+		base := strings.TrimSuffix(path.Base(f), ".sql")
+
+		v, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.sql", f)
+		}
+
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version: %w", f, err)
+		}
+
+		b, err := migrationFiles.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			sql:     strings.ReplaceAll(string(b), "${schema}", schema),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Migrate connects to the database and applies all pending migrations
+func Migrate(ctx context.Context, cfg StorageConfig) error {
+	pool, err := connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	return migrate(ctx, pool, cfg.Schema)
+}
+
+// MigrationStatus connects to the database and returns all known migrations along with the time they were applied
+func MigrationStatus(ctx context.Context, cfg StorageConfig) ([]Migration, error) {
+	pool, err := connect(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Close()
+
+	migrations, err := loadMigrations(cfg.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(ctx, pool, cfg.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range migrations {
+		if t, ok := applied[migrations[i].Version]; ok {
+			migrations[i].AppliedAt = &t
+		}
+	}
+
+	return migrations, nil
+}
+
+func migrate(ctx context.Context, pool *pgxpool.Pool, schema string) error {
+	migrations, err := loadMigrations(schema)
+	if err != nil {
+		return err
+	}
+
+	return withSchemaLock(ctx, pool, schema, func(conn *pgxpool.Conn) error {
+		return applyMigrations(ctx, conn, schema, migrations)
+	})
+}
+
+// withSchemaLock calls fn with a connection that holds a lock on the schema, which serializes changes to
+// the tables between instances that are started at the same time
+func withSchemaLock(ctx context.Context, pool *pgxpool.Pool, schema string, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	lockKey := "migrate:" + schema
+	_, err = conn.Exec(ctx, "SELECT pg_advisory_lock(hashtext($1))", lockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", lockKey)
+
+	return fn(conn)
+}
+
+func applyMigrations(ctx context.Context, conn *pgxpool.Conn, schema string, migrations []Migration) error {
+	log := logging.GetFromContext(ctx)
+
+	_, err := conn.Exec(ctx, fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %[1]s;
+		CREATE TABLE IF NOT EXISTS %[1]s.schemaMigrations ("version" integer PRIMARY KEY, "name" text NOT NULL, "appliedAt" timestamptz NOT NULL DEFAULT now());`, schema))
+	if err != nil {
+		return fmt.Errorf("failed to create migration status table: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, conn, schema)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		log.Info().Msgf("applying migration %04d %s", m.Version, m.Name)
+
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, m.sql)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(ctx, fmt.Sprintf(`INSERT INTO %s.schemaMigrations ("version", "name") VALUES ($1, $2)`, schema), m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d %s failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func appliedMigrations(ctx context.Context, q querier, schema string) (map[int]time.Time, error) {
+	applied := map[int]time.Time{}
+
+	// the status table does not exist until the first migration has been run
+	rows, err := q.Query(ctx, `SELECT to_regclass($1) IS NOT NULL`, schema+".schemamigrations")
+	if err != nil {
+		return nil, err
+	}
+	exists, err := pgx.CollectOneRow(rows, pgx.RowTo[bool])
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	rows, err = q.Query(ctx, fmt.Sprintf(`SELECT "version", "appliedAt" FROM %s.schemaMigrations`, schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
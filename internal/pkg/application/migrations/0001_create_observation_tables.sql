@@ -0,0 +1,51 @@
+CREATE EXTENSION IF NOT EXISTS postgis;
+
+CREATE TABLE IF NOT EXISTS ${schema}.waterConsumptionObserved
+(
+    "id" text COLLATE pg_catalog."default" NOT NULL,
+    "waterConsumption" numeric,
+    "unitCode" text COLLATE pg_catalog."default",
+    "observedAt" timestamp,
+    "source" text,
+    "location" geometry(Geometry, 4326),
+    "createdAt" timestamp,
+    CONSTRAINT pkey_wco PRIMARY KEY("id", "observedAt")
+);
+
+CREATE OR REPLACE VIEW ${schema}."latestWaterConsumptionObserved"
+ AS select distinct on ("id") "id", "waterConsumption", "unitCode", "source", "location", "observedAt"
+from ${schema}.waterconsumptionobserved
+order by id, "observedAt" desc;
+
+CREATE TABLE IF NOT EXISTS ${schema}.indoorEnvironmentObserved
+(
+    "id" text COLLATE pg_catalog."default" NOT NULL,
+    "temperature" numeric,
+    "humidity" numeric,
+    "observedAt" timestamp,
+    "source" text,
+    "location" geometry(Geometry, 4326),
+    "createdAt" timestamp,
+    CONSTRAINT pkey_ieo PRIMARY KEY("id", "observedAt")
+);
+
+CREATE OR REPLACE VIEW ${schema}."latestIndoorEnvironmentObserved"
+ AS select distinct on ("id") "id", "temperature", "humidity", "source", "location", "observedAt"
+from ${schema}.indoorEnvironmentObserved
+order by id, "observedAt" desc;
+
+CREATE TABLE IF NOT EXISTS ${schema}.weatherObserved
+(
+    "id" text COLLATE pg_catalog."default" NOT NULL,
+    "temperature" numeric,
+    "observedAt" timestamp,
+    "source" text,
+    "location" geometry(Geometry, 4326),
+    "createdAt" timestamp,
+    CONSTRAINT pkey_wo PRIMARY KEY("id", "observedAt")
+);
+
+CREATE OR REPLACE VIEW ${schema}."latestWeatherObserved"
+ AS select distinct on ("id") "id", "temperature", "source", "location", "observedAt"
+from ${schema}.weatherObserved
+order by id, "observedAt" desc;
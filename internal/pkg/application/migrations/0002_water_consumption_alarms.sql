@@ -0,0 +1,22 @@
+ALTER TABLE ${schema}.waterConsumptionObserved
+    ADD COLUMN IF NOT EXISTS "alarmStopsLeaks" boolean,
+    ADD COLUMN IF NOT EXISTS "alarmTamper" boolean,
+    ADD COLUMN IF NOT EXISTS "alarmMetrology" boolean,
+    ADD COLUMN IF NOT EXISTS "alarmWaterQuality" boolean,
+    ADD COLUMN IF NOT EXISTS "alarmFlowPersistence" text,
+    ADD COLUMN IF NOT EXISTS "alarmSystem" boolean,
+    ADD COLUMN IF NOT EXISTS "alarmInProgress" boolean,
+    ADD COLUMN IF NOT EXISTS "moduleTampered" boolean,
+    ADD COLUMN IF NOT EXISTS "acquisitionStageFailure" boolean,
+    ADD COLUMN IF NOT EXISTS "maxFlow" numeric,
+    ADD COLUMN IF NOT EXISTS "minFlow" numeric,
+    ADD COLUMN IF NOT EXISTS "persistenceFlowDuration" text;
+
+DROP VIEW IF EXISTS ${schema}."latestWaterConsumptionObserved";
+
+CREATE VIEW ${schema}."latestWaterConsumptionObserved"
+ AS select distinct on ("id") "id", "waterConsumption", "unitCode", "source", "location", "observedAt",
+    "alarmStopsLeaks", "alarmTamper", "alarmMetrology", "alarmWaterQuality", "alarmFlowPersistence", "alarmSystem",
+    "alarmInProgress", "moduleTampered", "acquisitionStageFailure", "maxFlow", "minFlow", "persistenceFlowDuration"
+from ${schema}.waterconsumptionobserved
+order by id, "observedAt" desc;
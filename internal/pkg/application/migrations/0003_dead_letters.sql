@@ -0,0 +1,14 @@
+-- The digest identifies an entity received on a subscription, so that the same entity is kept only once when
+-- a notification is replayed from the spool or a transaction is retried after it was committed
+CREATE TABLE IF NOT EXISTS ${schema}.deadLetter
+(
+    "id" bigserial PRIMARY KEY,
+    "entityId" text,
+    "entityType" text,
+    "subscriptionId" text,
+    "entity" jsonb NOT NULL,
+    "error" text,
+    "createdAt" timestamp,
+    "digest" text GENERATED ALWAYS AS (md5(COALESCE("subscriptionId", '') || ' ' || "entity"::text)) STORED,
+    CONSTRAINT deadLetter_digest_key UNIQUE ("digest")
+);
@@ -0,0 +1,3 @@
+ALTER TABLE ${schema}.waterConsumptionObserved ADD COLUMN IF NOT EXISTS "validationErrors" text[];
+ALTER TABLE ${schema}.indoorEnvironmentObserved ADD COLUMN IF NOT EXISTS "validationErrors" text[];
+ALTER TABLE ${schema}.weatherObserved ADD COLUMN IF NOT EXISTS "validationErrors" text[];
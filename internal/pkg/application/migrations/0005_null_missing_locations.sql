@@ -0,0 +1,4 @@
+-- observations without a location used to be stored at POINT(0 0)
+UPDATE ${schema}.waterConsumptionObserved SET "location" = NULL WHERE ST_AsText("location") = 'POINT(0 0)';
+UPDATE ${schema}.indoorEnvironmentObserved SET "location" = NULL WHERE ST_AsText("location") = 'POINT(0 0)';
+UPDATE ${schema}.weatherObserved SET "location" = NULL WHERE ST_AsText("location") = 'POINT(0 0)';
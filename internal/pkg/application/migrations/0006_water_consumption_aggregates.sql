@@ -0,0 +1,116 @@
+-- Water consumption per meter and hour, day and month. Every reading stores its consumption since the previous reading
+-- of the same meter, which counts towards the periods of the reading, so usage between two periods counts towards the later.
+ALTER TABLE ${schema}.waterConsumptionObserved
+    ADD COLUMN IF NOT EXISTS "consumptionDelta" numeric;
+
+CREATE TABLE IF NOT EXISTS ${schema}.waterConsumptionAggregate
+(
+    "id" text NOT NULL,
+    "granularity" text NOT NULL,
+    "period" timestamp NOT NULL,
+    "consumption" numeric NOT NULL DEFAULT 0,
+    "minFlow" numeric,
+    "maxFlow" numeric,
+    "readings" integer NOT NULL DEFAULT 0,
+    "updatedAt" timestamp NOT NULL,
+    CONSTRAINT pkey_wca PRIMARY KEY("id", "granularity", "period")
+);
+
+CREATE OR REPLACE VIEW ${schema}."waterConsumptionHourly" AS
+SELECT "id", "period", "consumption", "minFlow", "maxFlow", "readings" FROM ${schema}.waterConsumptionAggregate WHERE "granularity" = 'hour';
+
+CREATE OR REPLACE VIEW ${schema}."waterConsumptionDaily" AS
+SELECT "id", "period", "consumption", "minFlow", "maxFlow", "readings" FROM ${schema}.waterConsumptionAggregate WHERE "granularity" = 'day';
+
+CREATE OR REPLACE VIEW ${schema}."waterConsumptionMonthly" AS
+SELECT "id", "period", "consumption", "minFlow", "maxFlow", "readings" FROM ${schema}.waterConsumptionAggregate WHERE "granularity" = 'month';
+
+-- Computes the delta of a reading when it is inserted, or when its consumption is updated
+CREATE OR REPLACE FUNCTION ${schema}.delta_water_consumption() RETURNS trigger AS $$
+DECLARE
+    previous numeric;
+BEGIN
+    -- serialize readings from the same meter so that they are compared with each other
+    PERFORM pg_advisory_xact_lock(hashtext('aggregate:' || NEW."id"));
+
+    SELECT "waterConsumption" INTO previous FROM ${schema}.waterConsumptionObserved
+    WHERE "id" = NEW."id" AND "observedAt" < NEW."observedAt" ORDER BY "observedAt" DESC LIMIT 1;
+
+    NEW."consumptionDelta" := NEW."waterConsumption" - previous;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Adds a new reading to the aggregates. Readings may arrive out of order, and a reading that is stored before an
+-- existing one takes over part of the consumption of the reading after it. The consumption of that reading is
+-- updated so that its delta is computed again, which in turn corrects the aggregates of its periods.
+CREATE OR REPLACE FUNCTION ${schema}.aggregate_water_consumption() RETURNS trigger AS $$
+DECLARE
+    g text;
+BEGIN
+    FOREACH g IN ARRAY ARRAY['hour', 'day', 'month'] LOOP
+        INSERT INTO ${schema}.waterConsumptionAggregate AS a ("id", "granularity", "period", "consumption", "minFlow", "maxFlow", "readings", "updatedAt")
+        VALUES (NEW."id", g, date_trunc(g, NEW."observedAt"), COALESCE(NEW."consumptionDelta", 0), NEW."minFlow", NEW."maxFlow", 1, current_timestamp)
+        ON CONFLICT ("id", "granularity", "period") DO UPDATE SET
+            "consumption" = a."consumption" + EXCLUDED."consumption",
+            "minFlow" = LEAST(a."minFlow", EXCLUDED."minFlow"),
+            "maxFlow" = GREATEST(a."maxFlow", EXCLUDED."maxFlow"),
+            "readings" = a."readings" + 1,
+            "updatedAt" = current_timestamp;
+    END LOOP;
+
+    UPDATE ${schema}.waterConsumptionObserved SET "waterConsumption" = "waterConsumption"
+    WHERE "id" = NEW."id" AND "observedAt" = (
+        SELECT MIN("observedAt") FROM ${schema}.waterConsumptionObserved WHERE "id" = NEW."id" AND "observedAt" > NEW."observedAt"
+    );
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Moves the difference to the aggregates when the delta of a stored reading changes
+CREATE OR REPLACE FUNCTION ${schema}.reaggregate_water_consumption() RETURNS trigger AS $$
+DECLARE
+    g text;
+BEGIN
+    IF NEW."consumptionDelta" IS NOT DISTINCT FROM OLD."consumptionDelta" THEN
+        RETURN NULL;
+    END IF;
+
+    FOREACH g IN ARRAY ARRAY['hour', 'day', 'month'] LOOP
+        UPDATE ${schema}.waterConsumptionAggregate
+        SET "consumption" = "consumption" + COALESCE(NEW."consumptionDelta", 0) - COALESCE(OLD."consumptionDelta", 0), "updatedAt" = current_timestamp
+        WHERE "id" = NEW."id" AND "granularity" = g AND "period" = date_trunc(g, NEW."observedAt");
+    END LOOP;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- compute the delta of the readings that were stored before the triggers existed and aggregate them
+UPDATE ${schema}.waterConsumptionObserved w SET "consumptionDelta" = p."delta"
+FROM (
+    SELECT "id", "observedAt", "waterConsumption" - LAG("waterConsumption") OVER (PARTITION BY "id" ORDER BY "observedAt") AS "delta"
+    FROM ${schema}.waterConsumptionObserved
+) p
+WHERE w."id" = p."id" AND w."observedAt" = p."observedAt";
+
+INSERT INTO ${schema}.waterConsumptionAggregate ("id", "granularity", "period", "consumption", "minFlow", "maxFlow", "readings", "updatedAt")
+SELECT "id", g, date_trunc(g, "observedAt"), COALESCE(SUM("consumptionDelta"), 0), MIN("minFlow"), MAX("maxFlow"), COUNT(*), current_timestamp
+FROM ${schema}.waterConsumptionObserved
+CROSS JOIN unnest(ARRAY['hour', 'day', 'month']) AS g
+GROUP BY "id", g, date_trunc(g, "observedAt")
+ON CONFLICT DO NOTHING;
+
+DROP TRIGGER IF EXISTS delta_water_consumption ON ${schema}.waterConsumptionObserved;
+CREATE TRIGGER delta_water_consumption BEFORE INSERT OR UPDATE OF "waterConsumption" ON ${schema}.waterConsumptionObserved
+FOR EACH ROW EXECUTE FUNCTION ${schema}.delta_water_consumption();
+
+DROP TRIGGER IF EXISTS aggregate_water_consumption ON ${schema}.waterConsumptionObserved;
+CREATE TRIGGER aggregate_water_consumption AFTER INSERT ON ${schema}.waterConsumptionObserved
+FOR EACH ROW EXECUTE FUNCTION ${schema}.aggregate_water_consumption();
+
+DROP TRIGGER IF EXISTS reaggregate_water_consumption ON ${schema}.waterConsumptionObserved;
+CREATE TRIGGER reaggregate_water_consumption AFTER UPDATE OF "waterConsumption", "consumptionDelta" ON ${schema}.waterConsumptionObserved
+FOR EACH ROW EXECUTE FUNCTION ${schema}.reaggregate_water_consumption();
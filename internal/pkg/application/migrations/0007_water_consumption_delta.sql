@@ -0,0 +1,83 @@
+ALTER TABLE ${schema}.waterConsumptionObserved
+    ADD COLUMN IF NOT EXISTS "observedBy" text,
+    ADD COLUMN IF NOT EXISTS "flowRate" numeric,
+    ADD COLUMN IF NOT EXISTS "registerReset" boolean,
+    ADD COLUMN IF NOT EXISTS "meterReplaced" boolean;
+
+-- The consumption since the previous reading of the same id and the average flow per hour in between. A register
+-- that has decreased is assumed to have been reset to zero, so the whole reading counts as consumption. If the
+-- reading is made by another device than the previous one the meter has been replaced and the consumption is unknown.
+CREATE OR REPLACE FUNCTION ${schema}.water_consumption_delta(
+    previous_value numeric, previous_observed_at timestamp, previous_device text,
+    value numeric, observed_at timestamp, device text,
+    OUT delta numeric, OUT flow_rate numeric, OUT register_reset boolean, OUT meter_replaced boolean) AS $$
+BEGIN
+    IF previous_value IS NULL OR value IS NULL THEN
+        RETURN;
+    END IF;
+
+    meter_replaced := previous_device IS NOT NULL AND device IS NOT NULL AND previous_device <> device;
+    register_reset := NOT meter_replaced AND value < previous_value;
+
+    IF meter_replaced THEN
+        RETURN;
+    ELSIF register_reset THEN
+        delta := value;
+    ELSE
+        delta := value - previous_value;
+    END IF;
+
+    IF observed_at > previous_observed_at THEN
+        flow_rate := delta / (EXTRACT(EPOCH FROM observed_at - previous_observed_at) / 3600);
+    END IF;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+-- Replaces the plain difference that the aggregates were computed from in 0006_water_consumption_aggregates. The
+-- triggers that call it, and that keep the aggregates in sync with the delta of each reading, are unchanged.
+CREATE OR REPLACE FUNCTION ${schema}.delta_water_consumption() RETURNS trigger AS $$
+DECLARE
+    previous record;
+    d record;
+BEGIN
+    -- serialize readings from the same meter so that they are compared with each other
+    PERFORM pg_advisory_xact_lock(hashtext('aggregate:' || NEW."id"));
+
+    SELECT "waterConsumption", "observedAt", "observedBy" INTO previous FROM ${schema}.waterConsumptionObserved
+    WHERE "id" = NEW."id" AND "observedAt" < NEW."observedAt" ORDER BY "observedAt" DESC LIMIT 1;
+
+    d := ${schema}.water_consumption_delta(previous."waterConsumption", previous."observedAt", previous."observedBy",
+        NEW."waterConsumption", NEW."observedAt", NEW."observedBy");
+
+    NEW."consumptionDelta" := d.delta;
+    NEW."flowRate" := d.flow_rate;
+    NEW."registerReset" := d.register_reset;
+    NEW."meterReplaced" := d.meter_replaced;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- compute the delta of the readings that are already stored again, now with resets handled, which corrects the
+-- aggregates of the periods where the delta changes
+UPDATE ${schema}.waterConsumptionObserved w
+SET "consumptionDelta" = d.delta, "flowRate" = d.flow_rate, "registerReset" = d.register_reset, "meterReplaced" = d.meter_replaced
+FROM (
+    SELECT "id", "observedAt", "waterConsumption", "observedBy",
+        LAG("waterConsumption") OVER r AS "previousValue", LAG("observedAt") OVER r AS "previousObservedAt", LAG("observedBy") OVER r AS "previousDevice"
+    FROM ${schema}.waterConsumptionObserved
+    WINDOW r AS (PARTITION BY "id" ORDER BY "observedAt")
+) p
+CROSS JOIN LATERAL ${schema}.water_consumption_delta(p."previousValue", p."previousObservedAt", p."previousDevice",
+    p."waterConsumption", p."observedAt", p."observedBy") d
+WHERE w."id" = p."id" AND w."observedAt" = p."observedAt";
+
+DROP VIEW IF EXISTS ${schema}."latestWaterConsumptionObserved";
+
+CREATE VIEW ${schema}."latestWaterConsumptionObserved"
+ AS select distinct on ("id") "id", "waterConsumption", "unitCode", "source", "location", "observedAt",
+    "alarmStopsLeaks", "alarmTamper", "alarmMetrology", "alarmWaterQuality", "alarmFlowPersistence", "alarmSystem",
+    "alarmInProgress", "moduleTampered", "acquisitionStageFailure", "maxFlow", "minFlow", "persistenceFlowDuration",
+    "observedBy", "consumptionDelta", "flowRate", "registerReset", "meterReplaced"
+from ${schema}.waterconsumptionobserved
+order by id, "observedAt" desc;
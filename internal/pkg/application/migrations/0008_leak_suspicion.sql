@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS ${schema}.leakSuspicion
+(
+    "id" bigserial PRIMARY KEY,
+    "entityId" text NOT NULL,
+    "rule" text NOT NULL,
+    "severity" text NOT NULL,
+    "windowStart" timestamp NOT NULL,
+    "windowEnd" timestamp NOT NULL,
+    "evidence" text,
+    "detectedAt" timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS leakSuspicion_entity_idx ON ${schema}.leakSuspicion ("entityId", "rule", "windowEnd");
@@ -0,0 +1,26 @@
+-- Consumption is converted to a canonical unit when it is received, see WCO_UNIT. The value and unit code that were
+-- received are kept for audit. Readings that are already stored were never converted, so they are their own originals.
+-- They are converted to the canonical unit when the service starts, which is then recorded as a storage setting.
+CREATE TABLE IF NOT EXISTS ${schema}.storageSetting
+(
+    "name" text PRIMARY KEY,
+    "value" text NOT NULL
+);
+
+ALTER TABLE ${schema}.waterConsumptionObserved
+    ADD COLUMN IF NOT EXISTS "originalWaterConsumption" numeric,
+    ADD COLUMN IF NOT EXISTS "originalUnitCode" text;
+
+UPDATE ${schema}.waterConsumptionObserved
+SET "originalWaterConsumption" = "waterConsumption", "originalUnitCode" = "unitCode"
+WHERE "originalWaterConsumption" IS NULL;
+
+DROP VIEW IF EXISTS ${schema}."latestWaterConsumptionObserved";
+
+CREATE VIEW ${schema}."latestWaterConsumptionObserved"
+ AS select distinct on ("id") "id", "waterConsumption", "unitCode", "source", "location", "observedAt",
+    "alarmStopsLeaks", "alarmTamper", "alarmMetrology", "alarmWaterQuality", "alarmFlowPersistence", "alarmSystem",
+    "alarmInProgress", "moduleTampered", "acquisitionStageFailure", "maxFlow", "minFlow", "persistenceFlowDuration",
+    "observedBy", "consumptionDelta", "flowRate", "registerReset", "meterReplaced", "originalWaterConsumption", "originalUnitCode"
+from ${schema}.waterconsumptionobserved
+order by id, "observedAt" desc;
@@ -0,0 +1,77 @@
+-- The time of the latest observation of every id, kept up to date when observations are stored, so that the latest
+-- views look up one row per id instead of sorting every stored observation.
+CREATE TABLE IF NOT EXISTS ${schema}.latestObservation
+(
+    "table" text NOT NULL,
+    "id" text NOT NULL,
+    "observedAt" timestamp NOT NULL,
+    CONSTRAINT pkey_lo PRIMARY KEY("table", "id")
+);
+
+-- Observations may arrive out of order, so an observation only replaces the latest one if it is newer. The table is
+-- given as the argument of the trigger, since TimescaleDB fires the triggers of a hypertable on its chunks.
+CREATE OR REPLACE FUNCTION ${schema}.track_latest_observation() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO ${schema}.latestObservation AS l ("table", "id", "observedAt")
+    VALUES (TG_ARGV[0], NEW."id", NEW."observedAt")
+    ON CONFLICT ("table", "id") DO UPDATE SET "observedAt" = EXCLUDED."observedAt"
+    WHERE l."observedAt" < EXCLUDED."observedAt";
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+INSERT INTO ${schema}.latestObservation ("table", "id", "observedAt")
+SELECT DISTINCT ON ("id") 'waterconsumptionobserved', "id", "observedAt" FROM ${schema}.waterConsumptionObserved
+WHERE "observedAt" IS NOT NULL ORDER BY "id", "observedAt" DESC
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ${schema}.latestObservation ("table", "id", "observedAt")
+SELECT DISTINCT ON ("id") 'indoorenvironmentobserved', "id", "observedAt" FROM ${schema}.indoorEnvironmentObserved
+WHERE "observedAt" IS NOT NULL ORDER BY "id", "observedAt" DESC
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ${schema}.latestObservation ("table", "id", "observedAt")
+SELECT DISTINCT ON ("id") 'weatherobserved', "id", "observedAt" FROM ${schema}.weatherObserved
+WHERE "observedAt" IS NOT NULL ORDER BY "id", "observedAt" DESC
+ON CONFLICT DO NOTHING;
+
+DROP TRIGGER IF EXISTS track_latest_observation ON ${schema}.waterConsumptionObserved;
+CREATE TRIGGER track_latest_observation AFTER INSERT ON ${schema}.waterConsumptionObserved
+FOR EACH ROW EXECUTE FUNCTION ${schema}.track_latest_observation('waterconsumptionobserved');
+
+DROP TRIGGER IF EXISTS track_latest_observation ON ${schema}.indoorEnvironmentObserved;
+CREATE TRIGGER track_latest_observation AFTER INSERT ON ${schema}.indoorEnvironmentObserved
+FOR EACH ROW EXECUTE FUNCTION ${schema}.track_latest_observation('indoorenvironmentobserved');
+
+DROP TRIGGER IF EXISTS track_latest_observation ON ${schema}.weatherObserved;
+CREATE TRIGGER track_latest_observation AFTER INSERT ON ${schema}.weatherObserved
+FOR EACH ROW EXECUTE FUNCTION ${schema}.track_latest_observation('weatherobserved');
+
+-- The views keep their columns, filters on them are applied to the latest observations only
+DROP VIEW IF EXISTS ${schema}."latestWaterConsumptionObserved";
+
+CREATE VIEW ${schema}."latestWaterConsumptionObserved"
+ AS select w."id", w."waterConsumption", w."unitCode", w."source", w."location", w."observedAt",
+    w."alarmStopsLeaks", w."alarmTamper", w."alarmMetrology", w."alarmWaterQuality", w."alarmFlowPersistence", w."alarmSystem",
+    w."alarmInProgress", w."moduleTampered", w."acquisitionStageFailure", w."maxFlow", w."minFlow", w."persistenceFlowDuration",
+    w."observedBy", w."consumptionDelta", w."flowRate", w."registerReset", w."meterReplaced", w."originalWaterConsumption", w."originalUnitCode"
+from ${schema}.latestObservation l
+join ${schema}.waterConsumptionObserved w on w."id" = l."id" and w."observedAt" = l."observedAt"
+where l."table" = 'waterconsumptionobserved';
+
+DROP VIEW IF EXISTS ${schema}."latestIndoorEnvironmentObserved";
+
+CREATE VIEW ${schema}."latestIndoorEnvironmentObserved"
+ AS select w."id", w."temperature", w."humidity", w."source", w."location", w."observedAt"
+from ${schema}.latestObservation l
+join ${schema}.indoorEnvironmentObserved w on w."id" = l."id" and w."observedAt" = l."observedAt"
+where l."table" = 'indoorenvironmentobserved';
+
+DROP VIEW IF EXISTS ${schema}."latestWeatherObserved";
+
+CREATE VIEW ${schema}."latestWeatherObserved"
+ AS select w."id", w."temperature", w."source", w."location", w."observedAt"
+from ${schema}.latestObservation l
+join ${schema}.weatherObserved w on w."id" = l."id" and w."observedAt" = l."observedAt"
+where l."table" = 'weatherobserved';
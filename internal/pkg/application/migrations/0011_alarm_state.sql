@@ -0,0 +1,28 @@
+-- The state of every alarm of every meter, as of the latest reading that it was observed in. Alerts are sent when
+-- the state changes, and it is shared by every instance of the service so that a change is only alerted once, also
+-- after a restart.
+CREATE TABLE IF NOT EXISTS ${schema}.alarmState
+(
+    "id" text NOT NULL,
+    "alarm" text NOT NULL,
+    "raised" boolean NOT NULL,
+    "observedAt" timestamp NOT NULL,
+    CONSTRAINT pkey_as PRIMARY KEY("id", "alarm")
+);
+
+-- alarms that are already raised should not be alerted again when the next reading is stored
+INSERT INTO ${schema}.alarmState ("id", "alarm", "raised", "observedAt")
+SELECT l."id", a."alarm", a."raised", l."observedAt"
+FROM ${schema}."latestWaterConsumptionObserved" l
+CROSS JOIN LATERAL (VALUES
+    ('alarmStopsLeaks', l."alarmStopsLeaks"),
+    ('alarmTamper', l."alarmTamper"),
+    ('alarmMetrology', l."alarmMetrology"),
+    ('alarmWaterQuality', l."alarmWaterQuality"),
+    ('alarmSystem', l."alarmSystem"),
+    ('alarmInProgress', l."alarmInProgress"),
+    ('moduleTampered', l."moduleTampered"),
+    ('acquisitionStageFailure', l."acquisitionStageFailure")
+) AS a("alarm", "raised")
+WHERE a."raised" IS NOT NULL
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,87 @@
+package application
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatMigrationsAreLoadedInOrder(t *testing.T) {
+	is := is.New(t)
+
+	migrations, err := loadMigrations("geodata_vattenmatare")
+	is.NoErr(err)
+
+	is.True(len(migrations) >= 2)
+	is.Equal(migrations[0].Version, 1)
+	is.Equal(migrations[0].Name, "create_observation_tables")
+
+	for i := 1; i < len(migrations); i++ {
+		is.True(migrations[i-1].Version < migrations[i].Version)
+	}
+}
+
+func TestThatMigrationsHonourTheConfiguredSchema(t *testing.T) {
+	is := is.New(t)
+
+	migrations, err := loadMigrations("another_schema")
+	is.NoErr(err)
+
+	for _, m := range migrations {
+		is.True(!strings.Contains(m.sql, "${schema}"))
+		is.True(!strings.Contains(m.sql, "geodata_vattenmatare"))
+	}
+
+	is.True(strings.Contains(migrations[0].sql, "another_schema.waterConsumptionObserved"))
+}
+
+func TestThatWaterConsumptionIsAggregatedFromTheDeltaOfEachReading(t *testing.T) {
+	is := is.New(t)
+
+	migrations, err := loadMigrations("geodata_vattenmatare")
+	is.NoErr(err)
+
+	aggregates := migrations[5]
+	is.Equal(aggregates.Name, "water_consumption_aggregates")
+
+	for _, view := range []string{`"waterConsumptionHourly"`, `"waterConsumptionDaily"`, `"waterConsumptionMonthly"`} {
+		is.True(strings.Contains(aggregates.sql, "CREATE OR REPLACE VIEW geodata_vattenmatare."+view))
+	}
+
+	is.True(strings.Contains(aggregates.sql, `ADD COLUMN IF NOT EXISTS "consumptionDelta" numeric`))
+	is.True(strings.Contains(aggregates.sql, `VALUES (NEW."id", g, date_trunc(g, NEW."observedAt"), COALESCE(NEW."consumptionDelta", 0)`))
+	is.True(strings.Contains(aggregates.sql, `BEFORE INSERT OR UPDATE OF "waterConsumption" ON geodata_vattenmatare.waterConsumptionObserved`))
+	is.True(strings.Contains(aggregates.sql, `AFTER UPDATE OF "waterConsumption", "consumptionDelta" ON geodata_vattenmatare.waterConsumptionObserved`))
+
+	// later migrations change how the delta is computed, but not how it is aggregated
+	for _, m := range migrations[6:] {
+		is.True(!strings.Contains(m.sql, "aggregate_water_consumption()"))
+		is.True(!strings.Contains(m.sql, "DELETE FROM geodata_vattenmatare.waterConsumptionAggregate"))
+	}
+}
+
+func TestThatTheLatestViewsOnlyReadTheLatestObservations(t *testing.T) {
+	is := is.New(t)
+
+	migrations, err := loadMigrations("geodata_vattenmatare")
+	is.NoErr(err)
+
+	latest := migrations[9]
+	is.Equal(latest.Name, "latest_observations")
+
+	// the triggers of a hypertable fire on its chunks, so the name of the table that fires it is not the observation table
+	is.True(strings.Contains(latest.sql, `VALUES (TG_ARGV[0], NEW."id", NEW."observedAt")`))
+
+	for _, m := range migrations {
+		is.True(!strings.Contains(m.sql, "TG_TABLE_NAME"))
+	}
+
+	for _, table := range observationTables {
+		is.True(strings.Contains(latest.sql, fmt.Sprintf(`AFTER INSERT ON geodata_vattenmatare.%s
+FOR EACH ROW EXECUTE FUNCTION geodata_vattenmatare.track_latest_observation('%s');`, table, strings.ToLower(table))))
+		is.True(strings.Contains(latest.sql, fmt.Sprintf(`join geodata_vattenmatare.%s w on w."id" = l."id" and w."observedAt" = l."observedAt"
+where l."table" = '%s';`, table, strings.ToLower(table))))
+	}
+}
@@ -1,6 +1,12 @@
 package application
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
 
 type Entity struct {
 	Id   string `json:"id"`
@@ -24,29 +30,211 @@ type Property struct {
 	} `json:"observedBy"`
 }
 
-type Point struct {
-	Type  string `json:"Type"`
-	Value struct {
-		Type        string    `json:"type"`
-		Coordinates []float64 `json:"coordinates"`
-	} `json:"value"`
+func (p *Property) value() *float64 {
+	if p == nil {
+		return nil
+	}
+	return &p.Value
+}
+
+// FlagProperty is an alarm or status property. Meters report these as 0/1, but booleans
+// and their string representations are accepted as well.
+type FlagProperty struct {
+	Value      bool   `json:"value"`
+	ObservedAt string `json:"observedAt"`
+}
+
+func (p *FlagProperty) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Value      json.RawMessage `json:"value"`
+		ObservedAt string          `json:"observedAt"`
+	}{}
+
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	p.ObservedAt = raw.ObservedAt
+	p.Value, err = parseFlag(raw.Value)
+
+	return err
+}
+
+func (p *FlagProperty) value() *bool {
+	if p == nil {
+		return nil
+	}
+	return &p.Value
+}
+
+func parseFlag(v json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(v, &b); err == nil {
+		return b, nil
+	}
+
+	var f float64
+	if err := json.Unmarshal(v, &f); err == nil {
+		return f != 0, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f != 0, nil
+		}
+	}
+
+	return false, fmt.Errorf("unable to parse %s as a flag", string(v))
+}
+
+// TextProperty is a property with a descriptive value, such as "Nothing to report" or "3h < 6h".
+// Values that are not strings are kept as their JSON representation.
+type TextProperty struct {
+	Value      string `json:"value"`
+	UnitCode   string `json:"unitCode,omitempty"`
+	ObservedAt string `json:"observedAt"`
+}
+
+func (p *TextProperty) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Value      json.RawMessage `json:"value"`
+		UnitCode   string          `json:"unitCode,omitempty"`
+		ObservedAt string          `json:"observedAt"`
+	}{}
+
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	p.UnitCode = raw.UnitCode
+	p.ObservedAt = raw.ObservedAt
+	p.Value = string(bytes.TrimSpace(raw.Value))
+
+	if len(raw.Value) > 0 && raw.Value[0] == '"' {
+		return json.Unmarshal(raw.Value, &p.Value)
+	}
+
+	return nil
+}
+
+func (p *TextProperty) value() *string {
+	if p == nil {
+		return nil
+	}
+	return &p.Value
 }
 
 type WaterConsumptionObserved struct {
 	Entity
-	WaterConsumption Property `json:"waterConsumption"`
-	Location         Point    `json:"location"`
+	WaterConsumption        Property      `json:"waterConsumption"`
+	Location                GeoProperty   `json:"location"`
+	AlarmStopsLeaks         *FlagProperty `json:"alarmStopsLeaks,omitempty"`
+	AlarmTamper             *FlagProperty `json:"alarmTamper,omitempty"`
+	AlarmMetrology          *FlagProperty `json:"alarmMetrology,omitempty"`
+	AlarmWaterQuality       *FlagProperty `json:"alarmWaterQuality,omitempty"`
+	AlarmFlowPersistence    *TextProperty `json:"alarmFlowPersistence,omitempty"`
+	AlarmSystem             *FlagProperty `json:"alarmSystem,omitempty"`
+	AlarmInProgress         *FlagProperty `json:"alarmInProgress,omitempty"`
+	ModuleTampered          *FlagProperty `json:"moduleTampered,omitempty"`
+	AcquisitionStageFailure *FlagProperty `json:"acquisitionStageFailure,omitempty"`
+	MaxFlow                 *Property     `json:"maxFlow,omitempty"`
+	MinFlow                 *Property     `json:"minFlow,omitempty"`
+	PersistenceFlowDuration *TextProperty `json:"persistenceFlowDuration,omitempty"`
+	flags                   validationFlags
+	// original is the consumption as it was received if it has been converted to another unit
+	original *Property
 }
 
 type IndoorEnvironmentObserved struct {
 	Entity
-	Temperature Property `json:"temperature,omitempty"`
-	Humidity    Property `json:"humidity,omitempty"`
-	Location    Point    `json:"location"`
+	Temperature Property    `json:"temperature,omitempty"`
+	Humidity    Property    `json:"humidity,omitempty"`
+	Location    GeoProperty `json:"location"`
+	flags       validationFlags
 }
 
 type WeatherObserved struct {
 	Entity
-	Temperature Property `json:"temperature,omitempty"`
-	Location    Point    `json:"location"`
+	Temperature Property    `json:"temperature,omitempty"`
+	Location    GeoProperty `json:"location"`
+	flags       validationFlags
+}
+
+type EntityStatus string
+
+const (
+	EntityStored      EntityStatus = "stored"
+	EntityAccepted    EntityStatus = "accepted"
+	EntitySpooled     EntityStatus = "spooled"
+	EntityDuplicate   EntityStatus = "duplicate"
+	EntityUnsupported EntityStatus = "unsupported"
+	EntityInvalid     EntityStatus = "invalid"
+	EntityFailed      EntityStatus = "failed"
+)
+
+type EntityResult struct {
+	Index  int          `json:"index"`
+	Id     string       `json:"id,omitempty"`
+	Type   string       `json:"type,omitempty"`
+	Status EntityStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+}
+
+type NotificationResult struct {
+	Stored      int            `json:"stored"`
+	Accepted    int            `json:"accepted"`
+	Spooled     int            `json:"spooled"`
+	Duplicates  int            `json:"duplicates"`
+	Unsupported int            `json:"unsupported"`
+	Invalid     int            `json:"invalid"`
+	Failed      int            `json:"failed"`
+	Entities    []EntityResult `json:"entities"`
+}
+
+func (nr *NotificationResult) add(r EntityResult) {
+	switch r.Status {
+	case EntityStored:
+		nr.Stored++
+	case EntityAccepted:
+		nr.Accepted++
+	case EntitySpooled:
+		nr.Spooled++
+	case EntityDuplicate:
+		nr.Duplicates++
+	case EntityUnsupported:
+		nr.Unsupported++
+	case EntityInvalid:
+		nr.Invalid++
+	case EntityFailed:
+		nr.Failed++
+	}
+
+	nr.Entities = append(nr.Entities, r)
+}
+
+// DeadLetter is an entity that could not be decoded or stored, kept so that it can be inspected and reprocessed
+type DeadLetter struct {
+	Id             int64           `json:"id"`
+	EntityId       string          `json:"entityId"`
+	EntityType     string          `json:"entityType"`
+	SubscriptionId string          `json:"subscriptionId"`
+	Entity         json.RawMessage `json:"entity"`
+	Error          string          `json:"error"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+func newDeadLetter(r EntityResult, subscriptionId string, e json.RawMessage) DeadLetter {
+	return DeadLetter{
+		EntityId:       r.Id,
+		EntityType:     r.Type,
+		SubscriptionId: subscriptionId,
+		Entity:         e,
+		Error:          r.Error,
+	}
 }
@@ -0,0 +1,146 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// project adds, or changes the reference system of, a "locationProjected" column in every observation table and
+// installs a trigger that keeps it in sync with the location, e.g. in SWEREF 99 12 00 (EPSG:3007) so that GIS clients
+// can use the tables without reprojection. The column is added to the latest views as well, also if a migration has
+// recreated them without it. The triggers are removed if srid is 0, but the column is kept. Compressed hypertables
+// can not be altered, so the column should be added before compression is enabled.
+func project(ctx context.Context, pool *pgxpool.Pool, schema string, srid int) error {
+	log := logging.GetFromContext(ctx)
+
+	// the tables are altered with the same lock as migrations
+	err := withSchemaLock(ctx, pool, schema, func(conn *pgxpool.Conn) error {
+		return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if srid != 0 {
+				_, err := tx.Exec(ctx, projectLocationFunction(schema))
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, table := range observationTables {
+				var current int
+				err := tx.QueryRow(ctx, `SELECT srid FROM geometry_columns WHERE f_table_schema = lower($1) AND f_table_name = lower($2) AND f_geometry_column = 'locationProjected'`, schema, table).Scan(&current)
+				if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+					return err
+				}
+
+				rows, err := tx.Query(ctx, `SELECT column_name FROM information_schema.columns WHERE table_schema = lower($1) AND table_name = $2 AND column_name <> 'locationProjected' ORDER BY ordinal_position`, schema, latestView(table))
+				if err != nil {
+					return err
+				}
+
+				columns, err := pgx.CollectRows(rows, pgx.RowTo[string])
+				if err != nil {
+					return err
+				}
+
+				compressed, err := compressionEnabled(ctx, tx, schema, table)
+				if err != nil {
+					return err
+				}
+
+				statements, err := projectionStatements(schema, table, columns, current, srid, compressed)
+				if err != nil {
+					return err
+				}
+
+				switch {
+				case srid != 0 && current == 0:
+					log.Info().Msgf("adding projected location in EPSG:%d to %s", srid, table)
+				case srid != 0 && current != srid:
+					log.Info().Msgf("changing projected location in %s from EPSG:%d to EPSG:%d", table, current, srid)
+				}
+
+				for _, statement := range statements {
+					_, err = tx.Exec(ctx, statement)
+					if err != nil {
+						return fmt.Errorf("failed to add projected location to %s: %w", table, err)
+					}
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure projected locations: %w", err)
+	}
+
+	return nil
+}
+
+// latestView returns the name of the view with the latest observations in table
+func latestView(table string) string {
+	return "latest" + strings.ToUpper(table[:1]) + table[1:]
+}
+
+// projectLocationFunction returns the statement that creates the trigger function that projects the location of an
+// observation, to the reference system given as the argument of the trigger
+func projectLocationFunction(schema string) string {
+	return fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s.project_location() RETURNS trigger AS $$
+	BEGIN
+		NEW."locationProjected" := ST_Transform(NEW."location", TG_ARGV[0]::integer);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`, schema)
+}
+
+// projectionStatements returns the statements that project the locations in table to srid, given the reference
+// system that they are currently projected to, or 0 if they are not, and the other columns of its latest view. The
+// view depends on the column, so it is dropped before the column changes and is then recreated with it. Every
+// stored observation is projected when the column is added or changed, which is refused if table is compressed.
+func projectionStatements(schema, table string, columns []string, current, srid int, compressed bool) ([]string, error) {
+	name := schema + "." + table
+
+	if srid == 0 {
+		return []string{fmt.Sprintf(`DROP TRIGGER IF EXISTS project_location ON %s`, name)}, nil
+	}
+
+	if current != srid && compressed {
+		return nil, fmt.Errorf("the projected location of %s can not be changed to EPSG:%d while compression is enabled, decompress its chunks and disable compression first", table, srid)
+	}
+
+	statements := []string{}
+
+	switch {
+	case current == 0:
+		statements = append(statements,
+			fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "locationProjected" geometry(Geometry, %d)`, name, srid),
+			fmt.Sprintf(`UPDATE %s SET "locationProjected" = ST_Transform("location", %d) WHERE "location" IS NOT NULL`, name, srid),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_location_projected_idx ON %s USING GIST ("locationProjected")`, table, name),
+		)
+	case current != srid:
+		statements = append(statements,
+			fmt.Sprintf(`DROP VIEW IF EXISTS %s."%s"`, schema, latestView(table)),
+			fmt.Sprintf(`ALTER TABLE %[1]s ALTER COLUMN "locationProjected" TYPE geometry(Geometry, %[2]d) USING ST_Transform("location", %[2]d)`, name, srid),
+		)
+	}
+
+	if len(columns) > 0 {
+		selected := make([]string, 0, len(columns)+1)
+		for _, c := range append(columns, "locationProjected") {
+			selected = append(selected, fmt.Sprintf(`w."%s"`, c))
+		}
+
+		statements = append(statements, fmt.Sprintf(`CREATE OR REPLACE VIEW %[1]s."%[2]s" AS SELECT %[3]s
+		FROM %[1]s.latestObservation l JOIN %[4]s w ON w."id" = l."id" AND w."observedAt" = l."observedAt"
+		WHERE l."table" = '%[5]s'`, schema, latestView(table), strings.Join(selected, ", "), name, strings.ToLower(table)))
+	}
+
+	return append(statements,
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS project_location ON %s`, name),
+		fmt.Sprintf(`CREATE TRIGGER project_location BEFORE INSERT OR UPDATE OF "location" ON %s FOR EACH ROW EXECUTE FUNCTION %s.project_location('%d')`, name, schema, srid),
+	), nil
+}
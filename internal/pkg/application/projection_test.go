@@ -0,0 +1,70 @@
+package application
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatTheReferenceSystemIsAnArgumentOfTheTrigger(t *testing.T) {
+	is := is.New(t)
+
+	is.True(strings.Contains(projectLocationFunction("geodata_vattenmatare"), `ST_Transform(NEW."location", TG_ARGV[0]::integer)`))
+
+	statements, err := projectionStatements("geodata_vattenmatare", "weatherObserved", []string{"id", "temperature"}, 0, 3007, false)
+	is.NoErr(err)
+
+	is.Equal(len(statements), 6)
+	is.Equal(statements[0], `ALTER TABLE geodata_vattenmatare.weatherObserved ADD COLUMN "locationProjected" geometry(Geometry, 3007)`)
+	is.Equal(statements[1], `UPDATE geodata_vattenmatare.weatherObserved SET "locationProjected" = ST_Transform("location", 3007) WHERE "location" IS NOT NULL`)
+	is.Equal(statements[5], `CREATE TRIGGER project_location BEFORE INSERT OR UPDATE OF "location" ON geodata_vattenmatare.weatherObserved FOR EACH ROW EXECUTE FUNCTION geodata_vattenmatare.project_location('3007')`)
+}
+
+func TestThatTheLatestViewsIncludeTheProjectedLocation(t *testing.T) {
+	is := is.New(t)
+
+	statements, err := projectionStatements("geodata_vattenmatare", "weatherObserved", []string{"id", "temperature"}, 3007, 3007, false)
+	is.NoErr(err)
+
+	is.Equal(len(statements), 3) // the view and the trigger
+	is.True(strings.HasPrefix(statements[0], `CREATE OR REPLACE VIEW geodata_vattenmatare."latestWeatherObserved" AS SELECT w."id", w."temperature", w."locationProjected"`))
+	is.True(strings.Contains(statements[0], `JOIN geodata_vattenmatare.weatherObserved w ON`))
+	is.True(strings.Contains(statements[0], `WHERE l."table" = 'weatherobserved'`))
+}
+
+func TestThatTheLatestViewIsRecreatedWhenTheReferenceSystemChanges(t *testing.T) {
+	is := is.New(t)
+
+	statements, err := projectionStatements("geodata_vattenmatare", "weatherObserved", []string{"id", "temperature"}, 3006, 3007, false)
+	is.NoErr(err)
+
+	is.Equal(len(statements), 5)
+	is.Equal(statements[0], `DROP VIEW IF EXISTS geodata_vattenmatare."latestWeatherObserved"`)
+	is.Equal(statements[1], `ALTER TABLE geodata_vattenmatare.weatherObserved ALTER COLUMN "locationProjected" TYPE geometry(Geometry, 3007) USING ST_Transform("location", 3007)`)
+	is.True(strings.HasPrefix(statements[2], `CREATE OR REPLACE VIEW geodata_vattenmatare."latestWeatherObserved"`))
+}
+
+func TestThatTheTriggerIsRemovedWithoutAReferenceSystem(t *testing.T) {
+	is := is.New(t)
+
+	statements, err := projectionStatements("geodata_vattenmatare", "weatherObserved", []string{"id", "temperature"}, 3007, 0, false)
+	is.NoErr(err)
+
+	is.Equal(statements, []string{`DROP TRIGGER IF EXISTS project_location ON geodata_vattenmatare.weatherObserved`})
+}
+
+func TestThatTheProjectionOfCompressedTablesIsNotChanged(t *testing.T) {
+	is := is.New(t)
+
+	_, err := projectionStatements("geodata_vattenmatare", "weatherObserved", []string{"id"}, 0, 3007, true)
+	is.True(err != nil)
+
+	_, err = projectionStatements("geodata_vattenmatare", "weatherObserved", []string{"id"}, 3006, 3007, true)
+	is.True(err != nil)
+
+	// the views and triggers can still be updated
+	statements, err := projectionStatements("geodata_vattenmatare", "weatherObserved", []string{"id"}, 3007, 3007, true)
+	is.NoErr(err)
+	is.Equal(len(statements), 3)
+}
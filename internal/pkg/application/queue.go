@@ -0,0 +1,109 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// QueueFullError is returned when a notification can not be queued. Clients should retry after the given duration.
+type QueueFullError struct {
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+	return "ingestion queue is full"
+}
+
+type job struct {
+	ctx context.Context
+	b   batch
+}
+
+// queue is a bounded in-process queue of observations that are persisted by a pool of workers
+type queue struct {
+	mu         sync.RWMutex
+	closed     bool
+	jobs       chan job
+	wg         sync.WaitGroup
+	retryAfter time.Duration
+	persist    func(context.Context, batch) (StoreResult, bool, error)
+	rejected   metric.Int64Counter
+}
+
+func newQueue(cfg QueueConfig, persist func(context.Context, batch) (StoreResult, bool, error)) *queue {
+	q := &queue{
+		jobs:       make(chan job, cfg.Size),
+		retryAfter: cfg.RetryAfter,
+		persist:    persist,
+	}
+
+	q.rejected, _ = meter.Int64Counter(
+		"ingest.queue.rejected",
+		metric.WithDescription("number of notifications rejected because the ingestion queue was full"),
+	)
+
+	meter.Int64ObservableGauge(
+		"ingest.queue.depth",
+		metric.WithDescription("number of notifications waiting in the ingestion queue"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(len(q.jobs)))
+			return nil
+		}),
+	)
+
+	for i := 0; i < cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+func (q *queue) enqueue(ctx context.Context, b batch) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return fmt.Errorf("ingestion queue is closed")
+	}
+
+	// the job outlives the request, so keep its values (logger, trace) but not its cancellation
+	j := job{ctx: context.WithoutCancel(ctx), b: b}
+
+	select {
+	case q.jobs <- j:
+		return nil
+	default:
+		q.rejected.Add(ctx, 1)
+		return &QueueFullError{RetryAfter: q.retryAfter}
+	}
+}
+
+func (q *queue) work() {
+	defer q.wg.Done()
+
+	for j := range q.jobs {
+		_, _, err := q.persist(j.ctx, j.b)
+		if err != nil {
+			log := logging.GetFromContext(j.ctx)
+			log.Error().Err(err).Msg("queued notification could not be stored")
+		}
+	}
+}
+
+// close stops accepting new jobs and waits for the workers to finish the queued ones
+func (q *queue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
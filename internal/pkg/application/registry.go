@@ -0,0 +1,84 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// EntityHandler decodes and validates a single entity of the type it has been registered for. The returned
+// observation is persisted together with the other observations in the same notification.
+type EntityHandler func(ctx context.Context, e json.RawMessage) (Observation, error)
+
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]EntityHandler
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: map[string]EntityHandler{},
+	}
+}
+
+// Register adds a handler for an entity type. Entity types are matched case insensitively
+// and registering the same type twice is considered a programming error.
+func (r *Registry) Register(entityType string, h EntityHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strings.ToLower(entityType)
+
+	if _, exists := r.handlers[key]; exists {
+		panic(fmt.Sprintf("an entity handler for %s has already been registered", entityType))
+	}
+
+	r.handlers[key] = h
+}
+
+func (r *Registry) Handler(entityType string) (EntityHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h, ok := r.handlers[strings.ToLower(entityType)]
+	return h, ok
+}
+
+var defaultRegistry = NewRegistry()
+
+// RegisterEntityHandler adds a handler to the registry used by New. It is meant to be called
+// from an init function in the file that declares the entity type.
+func RegisterEntityHandler(entityType string, h EntityHandler) {
+	defaultRegistry.Register(entityType, h)
+}
+
+type validator interface {
+	Validate() error
+}
+
+// NewEntityHandler returns an EntityHandler that unmarshals entities into T and validates
+// them if T implements Validate() error.
+func NewEntityHandler[T Observation]() EntityHandler {
+	return func(ctx context.Context, e json.RawMessage) (Observation, error) {
+		log := logging.GetFromContext(ctx)
+
+		var o T
+		err := json.Unmarshal(e, &o)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to unmarshal notification entity into %T", o)
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEntity, err.Error())
+		}
+
+		if v, ok := any(o).(validator); ok {
+			if err = v.Validate(); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidEntity, err.Error())
+			}
+		}
+
+		return o, nil
+	}
+}
@@ -0,0 +1,169 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type RetryConfig struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// commitError is returned when a commit fails without a response from the server, e.g. because the connection
+// was lost after the commit was sent. The transaction may have been committed, so it is not retried.
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return "outcome of commit is unknown: " + e.err.Error()
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
+
+type beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// transaction calls fn in a transaction that is committed if fn succeeds and rolled back otherwise. Errors
+// from the commit are returned as a commitError unless the server reported why the commit failed.
+func transaction(ctx context.Context, db beginner, fn func(pgx.Tx) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+
+	var pgErr *pgconn.PgError
+	if err != nil && !errors.As(err, &pgErr) {
+		return &commitError{err: err}
+	}
+
+	return err
+}
+
+// errorClass returns the reason an error is considered transient, or an empty string if retrying
+// the same statement would fail again (constraint violations, invalid data, syntax errors and so on)
+// or could apply it twice
+func errorClass(err error) string {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ""
+	}
+
+	var commitErr *commitError
+	if errors.As(err, &commitErr) {
+		return ""
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"):
+			return "connection_exception"
+		case pgErr.Code == "40001":
+			return "serialization_failure"
+		case pgErr.Code == "40P01":
+			return "deadlock_detected"
+		case pgErr.Code == "53300":
+			return "too_many_connections"
+		case pgErr.Code == "57P01":
+			return "admin_shutdown"
+		case pgErr.Code == "57P02":
+			return "crash_shutdown"
+		case pgErr.Code == "57P03":
+			return "cannot_connect_now"
+		default:
+			return ""
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return "network"
+	}
+
+	if pgconn.SafeToRetry(err) {
+		return "connection"
+	}
+
+	return ""
+}
+
+// backoff returns a random duration between zero and the exponential backoff for the attempt, capped at max
+func backoff(attempt int, initial, max time.Duration) time.Duration {
+	d := initial << attempt
+	if d <= 0 || d > max {
+		d = max
+	}
+
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+type retrier struct {
+	cfg     RetryConfig
+	retries metric.Int64Counter
+}
+
+func newRetrier(cfg RetryConfig) *retrier {
+	r := &retrier{cfg: cfg}
+	r.retries, _ = meter.Int64Counter("db.retries", metric.WithDescription("number of retried database operations"))
+	return r
+}
+
+// do calls fn until it succeeds, returns an error that is not transient, the attempts are exhausted or the
+// next attempt would not start before the deadline of ctx. Retries are recorded on the span in ctx.
+func (r *retrier) do(ctx context.Context, operation string, fn func(context.Context) error) error {
+	span := trace.SpanFromContext(ctx)
+
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+
+		class := errorClass(err)
+		if class == "" || attempt+1 >= r.cfg.MaxAttempts {
+			span.SetAttributes(attribute.Int("db.retries", attempt))
+			return err
+		}
+
+		delay := backoff(attempt, r.cfg.InitialBackoff, r.cfg.MaxBackoff)
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			span.SetAttributes(attribute.Int("db.retries", attempt))
+			return err
+		}
+
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.String("reason", class),
+			attribute.String("error", err.Error()),
+		))
+		r.retries.Add(ctx, 1, metric.WithAttributes(attribute.String("operation", operation), attribute.String("reason", class)))
+
+		select {
+		case <-ctx.Done():
+			span.SetAttributes(attribute.Int("db.retries", attempt))
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/matryer/is"
+)
+
+func TestErrorClass(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(errorClass(&pgconn.PgError{Code: "40001"}), "serialization_failure")
+	is.Equal(errorClass(&pgconn.PgError{Code: "40P01"}), "deadlock_detected")
+	is.Equal(errorClass(&pgconn.PgError{Code: "57P01"}), "admin_shutdown")
+	is.Equal(errorClass(&pgconn.PgError{Code: "08006"}), "connection_exception")
+	is.Equal(errorClass(fmt.Errorf("observation [0]: %w", &pgconn.PgError{Code: "40P01"})), "deadlock_detected")
+	is.Equal(errorClass(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), "network")
+
+	is.Equal(errorClass(&pgconn.PgError{Code: "23505"}), "") // unique violation
+	is.Equal(errorClass(&pgconn.PgError{Code: "22P02"}), "") // invalid text representation
+	is.Equal(errorClass(context.DeadlineExceeded), "")
+	is.Equal(errorClass(errors.New("something else")), "")
+}
+
+func TestThatTransientErrorsAreRetried(t *testing.T) {
+	is := is.New(t)
+
+	r := newRetrier(RetryConfig{MaxAttempts: 5, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+
+	attempts := 0
+	err := r.do(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return &pgconn.PgError{Code: "40001"}
+		}
+		return nil
+	})
+
+	is.NoErr(err)
+	is.Equal(attempts, 3)
+}
+
+func TestThatPermanentErrorsAreNotRetried(t *testing.T) {
+	is := is.New(t)
+
+	r := newRetrier(RetryConfig{MaxAttempts: 5, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+
+	attempts := 0
+	err := r.do(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		return &pgconn.PgError{Code: "23502"}
+	})
+
+	is.True(err != nil)
+	is.Equal(attempts, 1)
+}
+
+func TestThatRetriesStopAtMaxAttemptsAndDeadline(t *testing.T) {
+	is := is.New(t)
+
+	r := newRetrier(RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	attempts := 0
+	err := r.do(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		return &pgconn.PgError{Code: "57P01"}
+	})
+	is.True(err != nil)
+	is.Equal(attempts, 3)
+
+	r = newRetrier(RetryConfig{MaxAttempts: 100, InitialBackoff: time.Second, MaxBackoff: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	attempts = 0
+	start := time.Now()
+	err = r.do(ctx, "test", func(ctx context.Context) error {
+		attempts++
+		return &pgconn.PgError{Code: "57P01"}
+	})
+	is.True(err != nil)
+	is.True(time.Since(start) < time.Second) // should not wait beyond the deadline
+}
+
+func TestBackoff(t *testing.T) {
+	is := is.New(t)
+
+	for attempt := 0; attempt < 40; attempt++ {
+		d := backoff(attempt, 100*time.Millisecond, 5*time.Second)
+		is.True(d >= 0 && d <= 5*time.Second)
+	}
+}
+
+type fakeTx struct {
+	pgx.Tx
+	commitErr error
+}
+
+func (tx fakeTx) Commit(ctx context.Context) error   { return tx.commitErr }
+func (tx fakeTx) Rollback(ctx context.Context) error { return nil }
+
+type fakeBeginner struct {
+	commitErr error
+}
+
+func (db fakeBeginner) Begin(ctx context.Context) (pgx.Tx, error) {
+	return fakeTx{commitErr: db.commitErr}, nil
+}
+
+func TestThatACommitWithUnknownOutcomeIsNotRetried(t *testing.T) {
+	is := is.New(t)
+
+	r := newRetrier(RetryConfig{MaxAttempts: 5, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+
+	attempts := 0
+	err := r.do(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		return transaction(ctx, fakeBeginner{commitErr: &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}}, func(tx pgx.Tx) error {
+			return nil
+		})
+	})
+
+	is.True(errors.As(err, new(*commitError)))
+	is.Equal(attempts, 1) // the transaction may have been committed
+
+	attempts = 0
+	err = r.do(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		return transaction(ctx, fakeBeginner{commitErr: &pgconn.PgError{Code: "40001"}}, func(tx pgx.Tx) error {
+			return nil
+		})
+	})
+
+	is.True(err != nil)
+	is.Equal(attempts, 5) // the server reported that the commit failed
+}
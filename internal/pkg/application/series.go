@@ -0,0 +1,104 @@
+package application
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Resolution is the length of the periods that a series is aggregated over
+type Resolution string
+
+const (
+	ResolutionRaw   Resolution = "raw"
+	ResolutionHour  Resolution = "hour"
+	ResolutionDay   Resolution = "day"
+	ResolutionMonth Resolution = "month"
+)
+
+// SeriesQuery selects the observations of an entity between From, inclusive, and To, exclusive. Periods
+// start at midnight, or the start of the hour, in Location, and the points are returned in Location as well.
+type SeriesQuery struct {
+	Type       string
+	Id         string
+	From       time.Time
+	To         time.Time
+	Resolution Resolution
+	Location   *time.Location
+}
+
+// SeriesPoint is a single observation, or the aggregate of the observations in the period starting at Time
+type SeriesPoint struct {
+	Time     time.Time           `json:"time"`
+	Readings int                 `json:"readings"`
+	Values   map[string]*float64 `json:"values"`
+}
+
+// measure is a value in a series, given by a column in raw series and an aggregate of it otherwise
+type measure struct {
+	name      string
+	column    string
+	aggregate string
+}
+
+type seriesTable struct {
+	table    string
+	measures []measure
+}
+
+// seriesTables holds the table and measures of the series of each entity type
+var seriesTables = map[string]seriesTable{
+	"waterconsumptionobserved": {
+		table: "waterConsumptionObserved",
+		measures: []measure{
+			// the register at the end of the period
+			{"waterConsumption", `"waterConsumption"`, `(array_agg("waterConsumption" ORDER BY "observedAt" DESC))[1]`},
+			{"consumptionDelta", `"consumptionDelta"`, `SUM("consumptionDelta")`},
+			{"flowRate", `"flowRate"`, `AVG("flowRate")`},
+		},
+	},
+	"weatherobserved": {
+		table: "weatherObserved",
+		measures: []measure{
+			{"temperature", `"temperature"`, `AVG("temperature")`},
+		},
+	},
+	"indoorenvironmentobserved": {
+		table: "indoorEnvironmentObserved",
+		measures: []measure{
+			{"temperature", `"temperature"`, `AVG("temperature")`},
+			{"humidity", `"humidity"`, `AVG("humidity")`},
+		},
+	},
+}
+
+// sql returns the statement for a series query. The parameters are the id, from, to and, unless the series
+// is raw, the name of the time zone.
+func (t seriesTable) sql(schema string, resolution Resolution) (string, error) {
+	columns := make([]string, 0, len(t.measures))
+
+	if resolution == ResolutionRaw {
+		for _, m := range t.measures {
+			columns = append(columns, m.column)
+		}
+
+		return fmt.Sprintf(`SELECT "observedAt" AT TIME ZONE 'UTC', 1, %s FROM %s.%s
+			WHERE "id" = $1 AND "observedAt" >= $2 AND "observedAt" < $3 ORDER BY "observedAt"`,
+			strings.Join(columns, ", "), schema, t.table), nil
+	}
+
+	switch resolution {
+	case ResolutionHour, ResolutionDay, ResolutionMonth:
+	default:
+		return "", fmt.Errorf("invalid resolution %s", resolution)
+	}
+
+	for _, m := range t.measures {
+		columns = append(columns, m.aggregate)
+	}
+
+	// observations are stored in UTC and grouped by the period they belong to in local time
+	return fmt.Sprintf(`SELECT date_trunc('%[1]s', "observedAt" AT TIME ZONE 'UTC' AT TIME ZONE $4) AT TIME ZONE $4 AS "period", COUNT(*), %[2]s
+		FROM %[3]s.%[4]s WHERE "id" = $1 AND "observedAt" >= $2 AND "observedAt" < $3 GROUP BY "period" ORDER BY "period"`,
+		resolution, strings.Join(columns, ", "), schema, t.table), nil
+}
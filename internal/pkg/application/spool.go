@@ -0,0 +1,246 @@
+package application
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var ErrSpoolFull = errors.New("spool is full")
+
+// spool is a write-ahead log of notifications that could not be stored. Notifications are appended as
+// JSON lines to segment files in a directory, and segments are removed once they have been replayed.
+type spool struct {
+	mu           sync.Mutex
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	current      *os.File
+	currentSize  int64
+	size         int64
+
+	written  metric.Int64Counter
+	replayed metric.Int64Counter
+	rejected metric.Int64Counter
+}
+
+func newSpool(cfg SpoolConfig) (*spool, error) {
+	err := os.MkdirAll(cfg.Dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create spool directory: %w", err)
+	}
+
+	s := &spool{
+		dir:          cfg.Dir,
+		maxBytes:     cfg.MaxBytes,
+		segmentBytes: cfg.SegmentBytes,
+	}
+
+	// segments left from a previous run are replayed as well
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, seg := range segments {
+		fi, err := os.Stat(seg)
+		if err != nil {
+			return nil, err
+		}
+		s.size += fi.Size()
+	}
+
+	s.written, _ = meter.Int64Counter("spool.written", metric.WithDescription("number of notifications written to the spool"))
+	s.replayed, _ = meter.Int64Counter("spool.replayed", metric.WithDescription("number of spooled notifications that have been stored"))
+	s.rejected, _ = meter.Int64Counter("spool.rejected", metric.WithDescription("number of notifications that could not be spooled because the spool was full"))
+
+	meter.Int64ObservableGauge(
+		"spool.size",
+		metric.WithDescription("number of bytes in the spool"),
+		metric.WithUnit("By"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			o.Observe(s.size)
+			return nil
+		}),
+	)
+
+	return s, nil
+}
+
+func (s *spool) write(n Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx := context.Background()
+
+	if s.size+int64(len(b)) > s.maxBytes {
+		s.rejected.Add(ctx, 1)
+		return ErrSpoolFull
+	}
+
+	if s.current == nil || s.currentSize >= s.segmentBytes {
+		err = s.rotate()
+		if err != nil {
+			return err
+		}
+
+		s.current, err = os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("%020d.jsonl", time.Now().UnixNano())), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.current.Write(b)
+	if err != nil {
+		return err
+	}
+
+	err = s.current.Sync()
+	if err != nil {
+		return err
+	}
+
+	s.currentSize += int64(len(b))
+	s.size += int64(len(b))
+	s.written.Add(ctx, 1)
+
+	return nil
+}
+
+// rotate closes the current segment so that it can be replayed. Must be called with the lock held.
+func (s *spool) rotate() error {
+	if s.current == nil {
+		return nil
+	}
+
+	err := s.current.Close()
+	s.current = nil
+	s.currentSize = 0
+
+	return err
+}
+
+func (s *spool) segments() ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(segments)
+
+	return segments, nil
+}
+
+func (s *spool) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.size == 0
+}
+
+// replay calls fn for every spooled notification, oldest first. A segment is removed once all of its
+// notifications have been handled and replay stops at the first error, leaving the rest for a later attempt.
+func (s *spool) replay(ctx context.Context, fn func(context.Context, Notification) error) error {
+	log := logging.GetFromContext(ctx)
+
+	// only closed segments are replayed, writes that happen meanwhile go to a new segment
+	s.mu.Lock()
+	err := s.rotate()
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	segments, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		count, err := replaySegment(ctx, seg, fn)
+		s.replayed.Add(ctx, int64(count))
+		if err != nil {
+			return fmt.Errorf("replay of %s stopped after %d notifications: %w", filepath.Base(seg), count, err)
+		}
+
+		fi, err := os.Stat(seg)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(seg)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.size -= fi.Size()
+		s.mu.Unlock()
+
+		log.Info().Msgf("replayed %d spooled notifications from %s", count, filepath.Base(seg))
+	}
+
+	return nil
+}
+
+func replaySegment(ctx context.Context, path string, fn func(context.Context, Notification) error) (int, error) {
+	log := logging.GetFromContext(ctx)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		n := Notification{}
+		err = json.Unmarshal(scanner.Bytes(), &n)
+		if err != nil {
+			// most likely a partial write before a crash, nothing can be done about it
+			log.Error().Err(err).Msgf("skipping unreadable line in %s", filepath.Base(path))
+			continue
+		}
+
+		// observations that are stored again are skipped by the database, by their id and time, and so
+		// are dead letters, by their subscription and entity, so it is safe to replay a segment that was
+		// only partly handled the last time
+		err = fn(ctx, n)
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, scanner.Err()
+}
+
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rotate()
+}
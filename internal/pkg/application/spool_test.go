@@ -0,0 +1,101 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatNotificationsAreSpooledWhenTheDatabaseIsUnavailable(t *testing.T) {
+	is, a, s := setupTest(t)
+
+	var err error
+	a.spool, err = newSpool(SpoolConfig{Dir: t.TempDir(), MaxBytes: 1024 * 1024, SegmentBytes: 1024, ReplayInterval: time.Minute})
+	is.NoErr(err)
+
+	storage := s.(*StorageMock)
+	storage.StoreFunc = func(ctx context.Context, obs []Observation) (StoreResult, error) {
+		return StoreResult{}, errors.New("connection refused")
+	}
+
+	n := createNotification()
+	result, err := a.NotificationReceived(context.Background(), n)
+	is.NoErr(err)
+	is.Equal(result.Spooled, 3)
+	is.True(!a.spool.empty())
+
+	storage.StoreFunc = func(ctx context.Context, obs []Observation) (StoreResult, error) {
+		return StoreResult{Inserted: len(obs), Duplicates: make([]bool, len(obs))}, nil
+	}
+
+	err = a.spool.replay(context.Background(), a.replay)
+	is.NoErr(err)
+
+	is.True(a.spool.empty())
+	is.Equal(len(storage.StoreCalls()), 2)
+	is.Equal(len(storage.StoreCalls()[1].Obs), 3) // the spooled entities should be stored on replay
+
+	segments, _ := a.spool.segments()
+	is.Equal(len(segments), 0)
+}
+
+func TestThatAFailedReplayKeepsTheSpool(t *testing.T) {
+	is := is.New(t)
+
+	sp, err := newSpool(SpoolConfig{Dir: t.TempDir(), MaxBytes: 1024 * 1024, SegmentBytes: 1024 * 1024})
+	is.NoErr(err)
+
+	is.NoErr(sp.write(createNotification()))
+	is.NoErr(sp.write(createNotification()))
+
+	calls := 0
+	err = sp.replay(context.Background(), func(ctx context.Context, n Notification) error {
+		calls++
+		if calls == 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	is.True(err != nil)
+	is.True(!sp.empty())
+
+	err = sp.replay(context.Background(), func(ctx context.Context, n Notification) error {
+		return nil
+	})
+	is.NoErr(err)
+	is.True(sp.empty())
+}
+
+func TestThatAFullSpoolRejectsNotifications(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+
+	sp, err := newSpool(SpoolConfig{Dir: dir, MaxBytes: 100, SegmentBytes: 100})
+	is.NoErr(err)
+
+	err = sp.write(createNotification())
+	is.True(errors.Is(err, ErrSpoolFull))
+
+	entries, _ := os.ReadDir(dir)
+	is.Equal(len(entries), 0)
+}
+
+func TestThatExistingSegmentsAreCountedOnStartup(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+
+	sp, err := newSpool(SpoolConfig{Dir: dir, MaxBytes: 1024 * 1024, SegmentBytes: 1024 * 1024})
+	is.NoErr(err)
+	is.NoErr(sp.write(createNotification()))
+	is.NoErr(sp.close())
+
+	sp, err = newSpool(SpoolConfig{Dir: dir, MaxBytes: 1024 * 1024, SegmentBytes: 1024 * 1024})
+	is.NoErr(err)
+	is.True(!sp.empty())
+}
@@ -0,0 +1,165 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TimescaleConfig selects the time-series storage mode, in which the observation tables are TimescaleDB
+// hypertables. Compression and retention are disabled if CompressAfter or RetainFor is 0. Stored readings are
+// updated when the delta of a reading changes or readings are converted to another unit, so compression requires
+// TimescaleDB 2.11 or later, which decompresses the affected rows of a compressed chunk to update them. The
+// projected location can not be added or changed once compression is enabled.
+type TimescaleConfig struct {
+	Enabled       bool
+	ChunkInterval time.Duration
+	CompressAfter time.Duration
+	RetainFor     time.Duration
+}
+
+func interval(d time.Duration) string {
+	return fmt.Sprintf("%d seconds", int64(d.Seconds()))
+}
+
+// updatesCompressedChunks returns true if a TimescaleDB version can update and delete rows in compressed chunks
+func updatesCompressedChunks(version string) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+
+	minor, err := strconv.Atoi(strings.TrimFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return false
+	}
+
+	return major > 2 || (major == 2 && minor >= 11)
+}
+
+// compressionEnabled returns true if table is a hypertable with compression enabled. It is false for tables in
+// databases without TimescaleDB.
+func compressionEnabled(ctx context.Context, tx pgx.Tx, schema, table string) (bool, error) {
+	var enabled bool
+	err := tx.QueryRow(ctx, `SELECT to_regclass('timescaledb_information.hypertables') IS NOT NULL`).Scan(&enabled)
+	if err != nil || !enabled {
+		return false, err
+	}
+
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM timescaledb_information.hypertables WHERE hypertable_schema = lower($1) AND hypertable_name = lower($2) AND compression_enabled)`, schema, table).Scan(&enabled)
+	return enabled, err
+}
+
+// timescale converts the observation tables to hypertables partitioned on the time of the observation, migrating
+// existing rows, and applies the configured chunk interval and policies. Changes to the chunk interval only affect
+// chunks that are created afterwards.
+func timescale(ctx context.Context, pool *pgxpool.Pool, schema string, cfg TimescaleConfig) error {
+	log := logging.GetFromContext(ctx)
+
+	err := withSchemaLock(ctx, pool, schema, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS timescaledb")
+		if err != nil {
+			return fmt.Errorf("timescaledb is not available: %w", err)
+		}
+
+		if cfg.CompressAfter > 0 {
+			var version string
+			err = conn.QueryRow(ctx, `SELECT extversion FROM pg_extension WHERE extname = 'timescaledb'`).Scan(&version)
+			if err != nil {
+				return err
+			}
+
+			if !updatesCompressedChunks(version) {
+				return fmt.Errorf("compression requires timescaledb 2.11 or later to update stored readings, found %s", version)
+			}
+		}
+
+		for _, table := range observationTables {
+			err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				name := schema + "." + table
+
+				var created bool
+				err := tx.QueryRow(ctx, `SELECT created FROM create_hypertable($1::regclass, 'observedAt', chunk_time_interval => $2::interval, migrate_data => true, if_not_exists => true)`, name, interval(cfg.ChunkInterval)).Scan(&created)
+				if err != nil {
+					return err
+				}
+
+				if created {
+					log.Info().Msgf("%s converted to a hypertable", table)
+				}
+
+				_, err = tx.Exec(ctx, `SELECT set_chunk_time_interval($1::regclass, $2::interval)`, name, interval(cfg.ChunkInterval))
+				if err != nil {
+					return err
+				}
+
+				// finds the latest observations of an id, such as the previous reading that a delta is computed from, in each chunk
+				_, err = tx.Exec(ctx, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[2]s_latest_idx ON %[1]s.%[2]s ("id", "observedAt" DESC)`, schema, table))
+				if err != nil {
+					return err
+				}
+
+				compressed, err := compressionEnabled(ctx, tx, schema, table)
+				if err != nil {
+					return err
+				}
+
+				if cfg.CompressAfter > 0 && !compressed {
+					_, err = tx.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s SET (timescaledb.compress, timescaledb.compress_segmentby = '"id"', timescaledb.compress_orderby = '"observedAt" DESC')`, name))
+					if err != nil {
+						return err
+					}
+				}
+
+				_, err = tx.Exec(ctx, `SELECT remove_compression_policy($1::regclass, if_exists => true)`, name)
+				if err != nil {
+					return err
+				}
+
+				if cfg.CompressAfter > 0 {
+					_, err = tx.Exec(ctx, `SELECT add_compression_policy($1::regclass, compress_after => $2::interval)`, name, interval(cfg.CompressAfter))
+					if err != nil {
+						return err
+					}
+				}
+
+				_, err = tx.Exec(ctx, `SELECT remove_retention_policy($1::regclass, if_exists => true)`, name)
+				if err != nil {
+					return err
+				}
+
+				if cfg.RetainFor > 0 {
+					_, err = tx.Exec(ctx, `SELECT add_retention_policy($1::regclass, drop_after => $2::interval)`, name, interval(cfg.RetainFor))
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to configure %s as a hypertable: %w", table, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("time-series storage with chunks of %s, compression after %s and retention of %s", cfg.ChunkInterval, cfg.CompressAfter, cfg.RetainFor)
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package application
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatCompressionRequiresUpdatableChunks(t *testing.T) {
+	is := is.New(t)
+
+	is.True(!updatesCompressedChunks("2.10.3"))
+	is.True(!updatesCompressedChunks("1.7.5"))
+	is.True(!updatesCompressedChunks(""))
+	is.True(updatesCompressedChunks("2.11.0"))
+	is.True(updatesCompressedChunks("2.14.2"))
+	is.True(updatesCompressedChunks("2.15-dev"))
+	is.True(updatesCompressedChunks("3.0.0"))
+}
@@ -0,0 +1,182 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// volumes holds the size in litres of the UN/CEFACT volume units that water consumption can be reported in
+var volumes = map[string]float64{
+	"MTQ": 1000,         // cubic metre
+	"LTR": 1,            // litre
+	"DMQ": 1,            // cubic decimetre
+	"HLT": 100,          // hectolitre
+	"MLT": 0.001,        // millilitre
+	"CMQ": 0.001,        // cubic centimetre
+	"FTQ": 28.316846592, // cubic foot
+	"GLI": 4.54609,      // gallon (UK)
+	"GLL": 3.785411784,  // gallon (US)
+}
+
+// defaultConsumptionUnit is assumed for water consumption that is reported without a unit code
+const defaultConsumptionUnit = "LTR"
+
+// convertVolume converts a value between two volume units. The result is rounded to 15 significant digits so
+// that converting back and forth between decimal units does not add floating point noise to stored values.
+func convertVolume(value float64, from, to string) (float64, bool) {
+	f, ok := volumes[from]
+	if !ok {
+		return value, false
+	}
+
+	t, ok := volumes[to]
+	if !ok {
+		return value, false
+	}
+
+	if from == to {
+		return value, true
+	}
+
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(value*f/t, 'g', 15, 64), 64)
+	return v, true
+}
+
+// normalisable is implemented by observations with values that are converted to a canonical unit before they are stored
+type normalisable interface {
+	normalised(consumptionUnit string) Observation
+}
+
+// normalise converts the values of an observation to the configured units. Observations with values in
+// units that are not known are rejected by the validation in every mode, so they are never stored.
+func (v *validation) normalise(o Observation) Observation {
+	n, ok := o.(normalisable)
+	if !ok || v.consumptionUnit == "" {
+		return o
+	}
+
+	return n.normalised(v.consumptionUnit)
+}
+
+// normalised returns a copy of the observation with the consumption in the given unit. The value and unit
+// code that were received are kept and stored alongside it.
+func (wco WaterConsumptionObserved) normalised(unit string) Observation {
+	from := wco.WaterConsumption.UnitCode
+	if from == "" {
+		from = defaultConsumptionUnit
+	}
+
+	value, ok := convertVolume(wco.WaterConsumption.Value, from, unit)
+	if !ok {
+		return wco
+	}
+
+	original := wco.WaterConsumption
+	wco.original = &original
+
+	wco.WaterConsumption.Value = value
+	wco.WaterConsumption.UnitCode = unit
+
+	return wco
+}
+
+// received returns the consumption and unit code as they were received
+func (wco WaterConsumptionObserved) received() (float64, *string) {
+	p := wco.WaterConsumption
+	if wco.original != nil {
+		p = *wco.original
+	}
+
+	return p.Value, nullIfEmpty(p.UnitCode)
+}
+
+// litres returns an expression for the number of litres per unit of the unit code in column, or NULL if the unit is
+// not known. Readings without a unit code are in the default unit.
+func litres(column string) string {
+	units := make([]string, 0, len(volumes))
+	for unit := range volumes {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+
+	cases := make([]string, 0, len(units))
+	for _, unit := range units {
+		cases = append(cases, fmt.Sprintf("WHEN '%s' THEN %s", unit, strconv.FormatFloat(volumes[unit], 'f', -1, 64)))
+	}
+
+	return fmt.Sprintf("(CASE COALESCE(NULLIF(%s, ''), '%s') %s END)", column, defaultConsumptionUnit, strings.Join(cases, " "))
+}
+
+// convertConsumptionStatement returns the statement that converts the stored readings to the unit given as the
+// first parameter and returns the ids of the meters whose readings were converted
+func convertConsumptionStatement(schema, unit string) string {
+	return fmt.Sprintf(`WITH converted AS (
+			UPDATE %[1]s.waterConsumptionObserved SET "waterConsumption" = "waterConsumption" * %[2]s / %[3]s, "unitCode" = $1
+			WHERE %[2]s IS NOT NULL AND COALESCE(NULLIF("unitCode", ''), '%[4]s') <> $1
+			RETURNING "id"
+		)
+		SELECT DISTINCT "id" FROM converted`, schema, litres(`"unitCode"`), strconv.FormatFloat(volumes[unit], 'f', -1, 64), defaultConsumptionUnit)
+}
+
+// storeConsumptionIn converts the water consumption of the stored readings to the unit that received readings are
+// converted to and records it, the first time that the service is started with a unit. Readings in units that are
+// not known are left as they are. The service refuses to start if the unit differs from the recorded one, since
+// readings and aggregates in different units can not be compared.
+func storeConsumptionIn(ctx context.Context, pool *pgxpool.Pool, schema, unit string) error {
+	log := logging.GetFromContext(ctx)
+
+	err := withSchemaLock(ctx, pool, schema, func(conn *pgxpool.Conn) error {
+		return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			var stored string
+			err := tx.QueryRow(ctx, fmt.Sprintf(`SELECT "value" FROM %s.storageSetting WHERE "name" = 'consumptionUnit'`, schema)).Scan(&stored)
+			if err == nil {
+				if stored != unit {
+					return fmt.Errorf("water consumption is stored in %s and can not be changed to %s, WCO_UNIT must be %s", stored, unit, stored)
+				}
+				return nil
+			}
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+
+			rows, err := tx.Query(ctx, convertConsumptionStatement(schema, unit), unit)
+			if err != nil {
+				return err
+			}
+
+			ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+			if err != nil {
+				return err
+			}
+
+			// the delta of each reading is computed by a trigger when its consumption is updated, but readings are
+			// not converted in order, so they are computed again once every reading of the meters has been converted
+			_, err = tx.Exec(ctx, fmt.Sprintf(`UPDATE %s.waterConsumptionObserved SET "waterConsumption" = "waterConsumption" WHERE "id" = ANY($1)`, schema), ids)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(ctx, fmt.Sprintf(`INSERT INTO %s.storageSetting ("name", "value") VALUES ('consumptionUnit', $1)`, schema), unit)
+			if err != nil {
+				return err
+			}
+
+			log.Info().Msgf("readings of %d meters converted to %s", len(ids), unit)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store water consumption in %s: %w", unit, err)
+	}
+
+	return nil
+}
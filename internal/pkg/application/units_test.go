@@ -0,0 +1,69 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatVolumesAreConverted(t *testing.T) {
+	is := is.New(t)
+
+	v, ok := convertVolume(191051, "LTR", "MTQ")
+	is.True(ok)
+	is.Equal(v, 191.051)
+
+	v, ok = convertVolume(191.051, "MTQ", "LTR")
+	is.True(ok)
+	is.Equal(v, 191051.0)
+
+	_, ok = convertVolume(1, "KWH", "LTR")
+	is.True(!ok)
+}
+
+func TestThatStoredReadingsAreConvertedToTheConfiguredUnit(t *testing.T) {
+	is := is.New(t)
+
+	sql := convertConsumptionStatement("geodata_vattenmatare", "MTQ")
+
+	is.True(strings.Contains(sql, `"waterConsumption" = "waterConsumption" * (CASE COALESCE(NULLIF("unitCode", ''), 'LTR') `))
+	is.True(strings.Contains(sql, "WHEN 'FTQ' THEN 28.316846592 "))
+	is.True(strings.Contains(sql, "WHEN 'MTQ' THEN 1000 "))
+	is.True(strings.Contains(sql, ` END) / 1000, "unitCode" = $1`)) // litres per cubic metre
+	is.True(strings.Contains(sql, `END) IS NOT NULL AND COALESCE(NULLIF("unitCode", ''), 'LTR') <> $1`))
+	is.True(strings.Contains(sql, `RETURNING "id"`))
+}
+
+func TestThatConsumptionIsNormalised(t *testing.T) {
+	is, a, _ := setupTest(t)
+	a.validation.consumptionUnit = "MTQ"
+
+	_, o := a.handleEntity(context.Background(), 0, createNotification().Entities[0])
+
+	_, args := o.insert(insertOptions{schema: "geodata_vattenmatare", source: "source"})
+	is.Equal(args[1].(float64), 191.051)   // waterConsumption
+	is.Equal(args[2].(string), "MTQ")      // unitCode
+	is.Equal(args[20].(float64), 191051.0) // originalWaterConsumption
+	is.Equal(*args[21].(*string), "LTR")   // originalUnitCode
+}
+
+func TestThatUnknownUnitsAreValidationErrors(t *testing.T) {
+	is, a, _ := setupTest(t)
+	a.validation.consumptionUnit = "MTQ"
+
+	entity := json.RawMessage(`{"id":"urn:ngsi-ld:WaterConsumptionObserved:01","type":"WaterConsumptionObserved","waterConsumption":{"value":12,"unitCode":"KWH","observedAt":"2023-01-31T12:45:54Z"}}`)
+
+	r, _ := a.handleEntity(context.Background(), 0, entity)
+	is.Equal(r.Status, EntityInvalid)
+	is.Equal(r.Error, `invalid entity: waterConsumption.unitCode: "KWH" is not a known unit of volume (unit)`)
+
+	// consumption in an unknown unit can not be stored with the converted consumption of other readings
+	a.validation.mode = ValidationFlag
+
+	r, o := a.handleEntity(context.Background(), 0, entity)
+	is.Equal(r.Status, EntityInvalid)
+	is.Equal(o, nil)
+}
@@ -0,0 +1,327 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ValidationMode decides what happens to an observation that violates one or more validation rules
+type ValidationMode string
+
+const (
+	// ValidationReject stores observations that violate a rule as dead letters
+	ValidationReject ValidationMode = "reject"
+	// ValidationFlag stores observations along with the rules they violate, unless they lack an id or a time. This is the default.
+	ValidationFlag ValidationMode = "flag"
+	// ValidationFixup corrects what can be corrected and rejects the observation if anything else remains
+	ValidationFixup ValidationMode = "fixup"
+)
+
+// Validation rules
+const (
+	RuleRequired    = "required"
+	RuleTimestamp   = "timestamp"
+	RuleCoordinates = "coordinates"
+	RuleRange       = "range"
+	RuleUnit        = "unit"
+)
+
+// Violation is a validation rule that a field in an observation does not comply with
+type Violation struct {
+	Rule    string `json:"rule"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// Fixed is set if the field was corrected in fix-up mode
+	Fixed bool `json:"fixed,omitempty"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s (%s)", v.Field, v.Message, v.Rule)
+}
+
+// blocking reports whether an observation with this violation can not be stored at all, since
+// every observation is keyed by its id and the time it was observed, and consumption in a unit that
+// can not be converted would be mixed with the consumption of other readings
+func (v Violation) blocking() bool {
+	return v.Rule == RuleRequired || v.Rule == RuleTimestamp || v.Rule == RuleUnit
+}
+
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	s := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		s = append(s, v.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+// validatable is implemented by observations that can be checked against the validation rules. validate
+// returns the violations it found and, if fixup is set, a copy of the observation with the fixable fields
+// corrected. flagged returns a copy of the observation that is stored along with the violations.
+type validatable interface {
+	validate(fixup bool) (Observation, []Violation)
+	flagged(violations []Violation) Observation
+}
+
+type validation struct {
+	mode ValidationMode
+	// consumptionUnit is the unit that water consumption is converted to, it is stored as received if empty
+	consumptionUnit string
+	failures        metric.Int64Counter
+}
+
+func newValidation(cfg ValidationConfig) *validation {
+	v := &validation{mode: cfg.Mode, consumptionUnit: cfg.ConsumptionUnit}
+	v.failures, _ = meter.Int64Counter("validation.failures", metric.WithDescription("number of validation rule violations in received observations"))
+	return v
+}
+
+// check validates an observation according to the configured mode and returns the observation that
+// should be stored, or an error wrapping ErrInvalidEntity if it should be rejected
+func (v *validation) check(ctx context.Context, entityType string, o Observation) (Observation, error) {
+	log := logging.GetFromContext(ctx)
+
+	obs, ok := o.(validatable)
+	if !ok {
+		return o, nil
+	}
+
+	fixed, violations := obs.validate(v.mode == ValidationFixup)
+	if len(violations) == 0 {
+		return o, nil
+	}
+
+	remaining := []Violation{}
+	rejected := false
+
+	for _, violation := range violations {
+		outcome := "rejected"
+
+		switch {
+		case violation.Fixed:
+			outcome = "fixed"
+		case v.mode == ValidationFlag && !violation.blocking():
+			outcome = "flagged"
+			remaining = append(remaining, violation)
+		default:
+			rejected = true
+			remaining = append(remaining, violation)
+		}
+
+		v.failures.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("type", entityType),
+			attribute.String("rule", violation.Rule),
+			attribute.String("field", violation.Field),
+			attribute.String("outcome", outcome),
+		))
+	}
+
+	if rejected {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEntity, &ValidationError{Violations: remaining})
+	}
+
+	if len(remaining) > 0 {
+		log.Debug().Msgf("storing %s with %d validation errors", entityType, len(remaining))
+		return fixed.(validatable).flagged(remaining), nil
+	}
+
+	log.Debug().Msgf("%d validation errors fixed in %s", len(violations), entityType)
+
+	return fixed, nil
+}
+
+// validationFlags holds the violations of an observation that is stored in flag mode
+type validationFlags struct {
+	violations []Violation
+}
+
+// validationErrors returns the violations as text, or nil if there are none so that NULL is stored
+func (f validationFlags) validationErrors() []string {
+	if len(f.violations) == 0 {
+		return nil
+	}
+
+	s := make([]string, 0, len(f.violations))
+	for _, v := range f.violations {
+		s = append(s, v.String())
+	}
+	return s
+}
+
+// unitRanges holds the plausible values for a property per UN/CEFACT unit code
+var unitRanges = map[string][2]float64{
+	"MTQ": {0, 1e7},  // cubic metre
+	"LTR": {0, 1e10}, // litre
+	"CEL": {-90, 70}, // degree Celsius
+	"P1":  {0, 100},  // percent
+}
+
+// timestampLayouts are accepted in fix-up mode and converted to RFC 3339. Timestamps without a zone are assumed to be in UTC.
+var timestampLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+type checker struct {
+	fixup      bool
+	violations []Violation
+}
+
+func (v *checker) add(rule, field, format string, args ...any) {
+	v.violations = append(v.violations, Violation{Rule: rule, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *checker) fixed(rule, field, format string, args ...any) {
+	v.violations = append(v.violations, Violation{Rule: rule, Field: field, Message: fmt.Sprintf(format, args...), Fixed: true})
+}
+
+func (v *checker) required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.add(RuleRequired, field, "is missing")
+		return false
+	}
+	return true
+}
+
+func (v *checker) timestamp(field string, value *string) {
+	if !v.required(field, *value) {
+		return
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, *value); err == nil {
+		return
+	}
+
+	if v.fixup {
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, *value); err == nil {
+				v.fixed(RuleTimestamp, field, "%q is not an RFC 3339 timestamp", *value)
+				*value = t.UTC().Format(time.RFC3339Nano)
+				return
+			}
+		}
+	}
+
+	v.add(RuleTimestamp, field, "%q is not an RFC 3339 timestamp", *value)
+}
+
+// location checks that a location, if there is one, is a valid GeoJSON geometry within WGS84. A point with
+// swapped coordinates is switched back and a location that can not be corrected is dropped in fix-up mode.
+func (v *checker) location(field string, p *GeoProperty) {
+	if p.Value.empty() {
+		return
+	}
+
+	err := p.Value.check()
+	if err == nil {
+		return
+	}
+
+	if v.fixup {
+		if g, ok := p.Value.swapped(); ok {
+			v.fixed(RuleCoordinates, field, "longitude and latitude are swapped")
+			p.Value = g
+			return
+		}
+
+		v.fixed(RuleCoordinates, field, "%s, the location is dropped", err.Error())
+		p.Value = Geometry{}
+		return
+	}
+
+	v.add(RuleCoordinates, field, "%s", err.Error())
+}
+
+// value checks that a value is finite and plausible for its unit. defaultUnit is used if the property has no unit code.
+func (v *checker) value(field string, p Property, defaultUnit string) {
+	if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+		v.add(RuleRange, field, "%g is not a finite number", p.Value)
+		return
+	}
+
+	unit := p.UnitCode
+	if unit == "" {
+		unit = defaultUnit
+	}
+
+	if r, ok := unitRanges[unit]; ok && (p.Value < r[0] || p.Value > r[1]) {
+		v.add(RuleRange, field, "%g %s is outside of %g to %g", p.Value, unit, r[0], r[1])
+	}
+}
+
+// volume checks that a unit code, if there is one, is a known unit of volume
+func (v *checker) volume(field, unit string) {
+	if _, ok := volumes[unit]; unit != "" && !ok {
+		v.add(RuleUnit, field, "%q is not a known unit of volume", unit)
+	}
+}
+
+func (wco WaterConsumptionObserved) validate(fixup bool) (Observation, []Violation) {
+	v := &checker{fixup: fixup}
+
+	v.required("id", wco.Id)
+	v.timestamp("waterConsumption.observedAt", &wco.WaterConsumption.ObservedAt)
+	v.volume("waterConsumption.unitCode", wco.WaterConsumption.UnitCode)
+	v.value("waterConsumption", wco.WaterConsumption, defaultConsumptionUnit)
+	v.location("location", &wco.Location)
+
+	return wco, v.violations
+}
+
+func (wco WaterConsumptionObserved) flagged(violations []Violation) Observation {
+	wco.flags.violations = violations
+	return wco
+}
+
+func (ieo IndoorEnvironmentObserved) validate(fixup bool) (Observation, []Violation) {
+	v := &checker{fixup: fixup}
+
+	v.required("id", ieo.Id)
+
+	// the observation is stored with the time of whichever property has one
+	if ieo.Temperature.ObservedAt != "" || ieo.Humidity.ObservedAt == "" {
+		v.timestamp("temperature.observedAt", &ieo.Temperature.ObservedAt)
+	}
+	if ieo.Humidity.ObservedAt != "" {
+		v.timestamp("humidity.observedAt", &ieo.Humidity.ObservedAt)
+	}
+
+	v.value("temperature", ieo.Temperature, "CEL")
+	v.value("humidity", ieo.Humidity, "P1")
+	v.location("location", &ieo.Location)
+
+	return ieo, v.violations
+}
+
+func (ieo IndoorEnvironmentObserved) flagged(violations []Violation) Observation {
+	ieo.flags.violations = violations
+	return ieo
+}
+
+func (wo WeatherObserved) validate(fixup bool) (Observation, []Violation) {
+	v := &checker{fixup: fixup}
+
+	v.required("id", wo.Id)
+	v.timestamp("temperature.observedAt", &wo.Temperature.ObservedAt)
+	v.value("temperature", wo.Temperature, "CEL")
+	v.location("location", &wo.Location)
+
+	return wo, v.violations
+}
+
+func (wo WeatherObserved) flagged(violations []Violation) Observation {
+	wo.flags.violations = violations
+	return wo
+}
@@ -0,0 +1,96 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/rs/zerolog"
+)
+
+const invalidWaterConsumptionObserved string = `{
+	"id": "urn:ngsi-ld:WaterConsumptionObserved:01",
+	"type": "WaterConsumptionObserved",
+	"waterConsumption": {"value": 191051, "unitCode": "LTR", "observedAt": "2021-05-23 23:14:16"},
+	"location": {"type": "GeoProperty", "value": {"type": "Point", "coordinates": [-33.9, 151.2]}}
+}`
+
+func TestThatInvalidObservationsAreRejected(t *testing.T) {
+	is, a, _ := setupTest(t)
+
+	n := Notification{Entities: []json.RawMessage{
+		json.RawMessage(invalidWaterConsumptionObserved),
+		json.RawMessage(`{"id":"","type":"WeatherObserved","temperature":{"value":21,"observedAt":"2023-01-31T12:45:54Z"},"location":{"value":{"coordinates":[11.9]}}}`),
+	}}
+
+	result, err := a.NotificationReceived(context.Background(), n)
+	is.NoErr(err)
+	is.Equal(result.Invalid, 2)
+
+	_, o := a.handleEntity(context.Background(), 1, n.Entities[1])
+	is.Equal(o, nil)
+}
+
+func TestThatFlaggedObservationsAreStoredWithTheirViolations(t *testing.T) {
+	is, a, _ := setupTest(t)
+	a.validation.mode = ValidationFlag
+
+	r, o := a.handleEntity(context.Background(), 0, json.RawMessage(`{"id":"urn:ngsi-ld:IndoorEnvironmentObserved:01","type":"IndoorEnvironmentObserved","temperature":{"value":21,"observedAt":"2023-01-31T12:45:54Z"},"humidity":{"value":140}}`))
+	is.Equal(r.Error, "")
+
+	_, args := o.insert(insertOptions{schema: "geodata_vattenmatare", source: "source"})
+	flags := args[len(args)-1].([]string)
+	is.Equal(len(flags), 1)
+	is.Equal(flags[0], "humidity: 140 P1 is outside of 0 to 100 (range)")
+
+	// observations without a time can not be stored even if flagged
+	r, o = a.handleEntity(context.Background(), 0, json.RawMessage(`{"id":"urn:ngsi-ld:WeatherObserved:01","type":"WeatherObserved","temperature":{"value":21}}`))
+	is.Equal(r.Status, EntityInvalid)
+	is.Equal(o, nil)
+}
+
+func TestThatObservationsAreFixedUp(t *testing.T) {
+	is, a, _ := setupTest(t)
+	a.validation.mode = ValidationFixup
+
+	_, o := a.handleEntity(context.Background(), 0, json.RawMessage(invalidWaterConsumptionObserved))
+
+	wco, ok := o.(WaterConsumptionObserved)
+	is.True(ok)
+	is.Equal(wco.WaterConsumption.ObservedAt, "2021-05-23T23:14:16Z")
+	is.Equal(string(wco.Location.Value.Coordinates), "[151.2,-33.9]")
+	is.Equal(wco.flags.validationErrors(), nil)
+}
+
+func TestThatObservationsThatCanNotBeFixedAreRejected(t *testing.T) {
+	is, a, _ := setupTest(t)
+	a.validation.mode = ValidationFixup
+
+	_, err := a.validation.check(context.Background(), "WeatherObserved", WeatherObserved{
+		Entity:      Entity{Id: "urn:ngsi-ld:WeatherObserved:01"},
+		Temperature: Property{Value: math.NaN(), ObservedAt: "2023-01-31T12:45:54Z"},
+	})
+
+	is.True(errors.Is(err, ErrInvalidEntity))
+
+	validationErr := &ValidationError{}
+	is.True(errors.As(err, &validationErr))
+	is.Equal(validationErr.Violations[0].Rule, RuleRange)
+}
+
+func TestThatObservationsAreFlaggedByDefault(t *testing.T) {
+	is := is.New(t)
+
+	cfg, err := LoadConfig(zerolog.Nop())
+	is.NoErr(err)
+	is.Equal(cfg.Validation.Mode, ValidationFlag) // rejection should be opted in to
+
+	t.Setenv("VALIDATION_MODE", "reject")
+
+	cfg, err = LoadConfig(zerolog.Nop())
+	is.NoErr(err)
+	is.Equal(cfg.Validation.Mode, ValidationReject)
+}
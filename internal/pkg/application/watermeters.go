@@ -0,0 +1,61 @@
+package application
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// WaterMeter is the latest reading of a meter along with its location and the state of its alarms
+type WaterMeter struct {
+	Id               string           `json:"id"`
+	WaterConsumption *float64         `json:"waterConsumption"`
+	UnitCode         *string          `json:"unitCode,omitempty"`
+	ObservedAt       time.Time        `json:"observedAt"`
+	ObservedBy       *string          `json:"observedBy,omitempty"`
+	ConsumptionDelta *float64         `json:"consumptionDelta,omitempty"`
+	FlowRate         *float64         `json:"flowRate,omitempty"`
+	MaxFlow          *float64         `json:"maxFlow,omitempty"`
+	MinFlow          *float64         `json:"minFlow,omitempty"`
+	Location         json.RawMessage  `json:"location,omitempty"`
+	Alarms           WaterMeterAlarms `json:"alarms"`
+}
+
+// WaterMeterAlarms holds the alarms of the latest reading. Alarms that were not reported are left out.
+type WaterMeterAlarms struct {
+	AlarmStopsLeaks         *bool   `json:"alarmStopsLeaks,omitempty"`
+	AlarmTamper             *bool   `json:"alarmTamper,omitempty"`
+	AlarmMetrology          *bool   `json:"alarmMetrology,omitempty"`
+	AlarmWaterQuality       *bool   `json:"alarmWaterQuality,omitempty"`
+	AlarmFlowPersistence    *string `json:"alarmFlowPersistence,omitempty"`
+	AlarmSystem             *bool   `json:"alarmSystem,omitempty"`
+	AlarmInProgress         *bool   `json:"alarmInProgress,omitempty"`
+	ModuleTampered          *bool   `json:"moduleTampered,omitempty"`
+	AcquisitionStageFailure *bool   `json:"acquisitionStageFailure,omitempty"`
+	PersistenceFlowDuration *string `json:"persistenceFlowDuration,omitempty"`
+}
+
+// waterMeterColumns are selected from the latestWaterConsumptionObserved view in the order scanned by rowToWaterMeter
+const waterMeterColumns = `"id", "waterConsumption", "unitCode", "observedAt", "observedBy", "consumptionDelta", "flowRate",
+	"maxFlow", "minFlow", ST_AsGeoJSON("location"), "alarmStopsLeaks", "alarmTamper", "alarmMetrology", "alarmWaterQuality",
+	"alarmFlowPersistence", "alarmSystem", "alarmInProgress", "moduleTampered", "acquisitionStageFailure", "persistenceFlowDuration"`
+
+func rowToWaterMeter(row pgx.CollectableRow) (WaterMeter, error) {
+	m := WaterMeter{}
+	var location *string
+
+	err := row.Scan(&m.Id, &m.WaterConsumption, &m.UnitCode, &m.ObservedAt, &m.ObservedBy, &m.ConsumptionDelta, &m.FlowRate,
+		&m.MaxFlow, &m.MinFlow, &location, &m.Alarms.AlarmStopsLeaks, &m.Alarms.AlarmTamper, &m.Alarms.AlarmMetrology,
+		&m.Alarms.AlarmWaterQuality, &m.Alarms.AlarmFlowPersistence, &m.Alarms.AlarmSystem, &m.Alarms.AlarmInProgress,
+		&m.Alarms.ModuleTampered, &m.Alarms.AcquisitionStageFailure, &m.Alarms.PersistenceFlowDuration)
+	if err != nil {
+		return m, err
+	}
+
+	if location != nil {
+		m.Location = json.RawMessage(*location)
+	}
+
+	return m, nil
+}
@@ -1,9 +1,18 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
@@ -22,44 +31,99 @@ var tracer = otel.Tracer("integration-cip-gbg-watermeter/api")
 
 type API interface {
 	Start(port string) error
+	Shutdown(ctx context.Context) error
 }
 
 type api struct {
-	log zerolog.Logger
-	r   chi.Router
-	app application.App
+	log    zerolog.Logger
+	r      chi.Router
+	app    application.App
+	cfg    Config
+	server *http.Server
 }
 
+// Start serves the api until it is shut down, after which it returns http.ErrServerClosed
 func (a *api) Start(port string) error {
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+
 	a.log.Info().Str("port", port).Msg("starting to listen for connections")
 
-	return http.ListenAndServe(":"+port, a.r)
+	return a.server.Serve(l)
+}
+
+// Shutdown stops accepting connections and waits for the requests in progress to complete, or for ctx to end
+func (a *api) Shutdown(ctx context.Context) error {
+	return a.server.Shutdown(ctx)
 }
 
-func New(logger zerolog.Logger, r chi.Router, app application.App) API {
-	a := newApi(logger, r, app)
+func New(logger zerolog.Logger, r chi.Router, app application.App, cfg Config) API {
+	a := newApi(logger, r, app, cfg)
 
 	return a
 }
 
-func newApi(logger zerolog.Logger, r chi.Router, app application.App) *api {
+func newApi(logger zerolog.Logger, r chi.Router, app application.App, cfg Config) *api {
 	a := &api{
-		log: logger,
-		r:   r,
-		app: app,
+		log:    logger,
+		r:      r,
+		app:    app,
+		cfg:    cfg,
+		server: &http.Server{Handler: r},
 	}
 
-	r.Use(cors.New(cors.Options{
+	r.Use(publicCORS(cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowCredentials: true,
 		Debug:            false,
-	}).Handler)
+	}).Handler))
+
+	if cfg.AdminToken == "" {
+		logger.Info().Msg("admin endpoints are disabled since ADMIN_API_TOKEN is not set")
+	}
 
 	registerHandlers(r, logger, *a)
 
 	return a
 }
 
+// publicCORS applies a CORS policy to every endpoint except the admin endpoints, which are not meant to be
+// called from browsers
+func publicCORS(policy func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withPolicy := policy(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, adminPath+"/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			withPolicy.ServeHTTP(w, r)
+		})
+	}
+}
+
+const adminPath = "/admin"
+
+// requireToken rejects requests that are not authorized with the bearer token
+func requireToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func registerHandlers(r chi.Router, log zerolog.Logger, a api) error {
 	r.Use(otelchi.Middleware("integration-cip-gbg-watermeter", otelchi.WithChiRoutes(r)))
 
@@ -73,6 +137,37 @@ func registerHandlers(r chi.Router, log zerolog.Logger, a api) error {
 		})
 	})
 
+	if a.cfg.AdminToken != "" {
+		r.Route(adminPath, func(r chi.Router) {
+			r.Use(requireToken(a.cfg.AdminToken))
+
+			r.Route("/deadletters", func(r chi.Router) {
+				r.Get("/", listDeadLettersHandlerFunc(a.app, a.log))
+				r.Get("/{id}", getDeadLetterHandlerFunc(a.app, a.log))
+				r.Post("/{id}/reprocess", reprocessDeadLetterHandlerFunc(a.app, a.log))
+				r.Delete("/{id}", discardDeadLetterHandlerFunc(a.app, a.log))
+			})
+		})
+	}
+
+	r.Route("/api/v0/watermeters", func(r chi.Router) {
+		r.Get("/", listWaterMetersHandlerFunc(a.app, a.log))
+		r.Get("/{id}", getWaterMeterHandlerFunc(a.app, a.log))
+		r.Get("/{id}/observations", seriesHandlerFunc(a.app, a.log, "WaterConsumptionObserved"))
+	})
+
+	r.Route("/api/v0/features", func(r chi.Router) {
+		r.Get("/", featuresHandlerFunc(a.app, a.log))
+		r.Post("/intersects", featuresHandlerFunc(a.app, a.log))
+	})
+
+	r.Get("/api/v0/geojson/{type}", geoJSONHandlerFunc(a.app, a.log))
+
+	r.Get("/api/v0/weather/{id}/observations", seriesHandlerFunc(a.app, a.log, "WeatherObserved"))
+	r.Get("/api/v0/indoorenvironment/{id}/observations", seriesHandlerFunc(a.app, a.log, "IndoorEnvironmentObserved"))
+
+	registerOGCHandlers(r, a)
+
 	return nil
 }
 
@@ -106,16 +201,576 @@ func notifyHandlerFunc(a application.App, log zerolog.Logger) http.HandlerFunc {
 			return
 		}
 
-		err = a.NotificationReceived(ctx, n)
+		result, err := a.NotificationReceived(ctx, n)
 		if err != nil {
 			log.Error().Err(err).Msg("handle notification")
 
+			queueFull := &application.QueueFullError{}
+			if errors.As(err, &queueFull) {
+				w.Header().Add("Retry-After", strconv.Itoa(int(math.Ceil(queueFull.RetryAfter.Seconds()))))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(notificationStatusCode(result))
+
+		b, _ := json.Marshal(result)
+		w.Write(b)
+	})
+}
+
+// notificationStatusCode maps the outcome of a notification to a status code. Duplicates and unsupported
+// types are not considered errors, a mix of handled and failed entities results in 207 Multi-Status.
+func notificationStatusCode(result application.NotificationResult) int {
+	handled := result.Stored + result.Accepted + result.Spooled + result.Duplicates + result.Unsupported
+
+	switch {
+	case result.Failed == 0 && result.Invalid == 0 && result.Accepted+result.Spooled > 0:
+		return http.StatusAccepted
+	case result.Failed == 0 && result.Invalid == 0:
+		return http.StatusOK
+	case handled > 0:
+		return http.StatusMultiStatus
+	case result.Failed > 0:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func listDeadLettersHandlerFunc(a application.App, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "list-dead-letters")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		offset, limit, err := queryPaging(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		deadLetters, err := a.DeadLetters(ctx, offset, limit)
+		if err != nil {
+			log.Error().Err(err).Msg("list dead letters")
 			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, deadLetters)
+	})
+}
+
+func getDeadLetterHandlerFunc(a application.App, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "get-dead-letter")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		dl, err := a.DeadLetter(ctx, id)
+		if err != nil {
+			writeDeadLetterError(w, log, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, dl)
+	})
+}
+
+func reprocessDeadLetterHandlerFunc(a application.App, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "reprocess-dead-letter")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		result, err := a.ReprocessDeadLetter(ctx, id)
+		if errors.Is(err, application.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("reprocess dead letter %d", id)
+		}
+
+		statusCode := http.StatusOK
+		switch result.Status {
+		case application.EntityFailed:
+			statusCode = http.StatusInternalServerError
+		case application.EntityInvalid, application.EntityUnsupported:
+			statusCode = http.StatusUnprocessableEntity
+		}
+
+		writeJSON(w, statusCode, result)
+	})
+}
+
+func discardDeadLetterHandlerFunc(a application.App, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "discard-dead-letter")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		err = a.DiscardDeadLetter(ctx, id)
+		if err != nil {
+			writeDeadLetterError(w, log, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func writeDeadLetterError(w http.ResponseWriter, log zerolog.Logger, err error) {
+	if errors.Is(err, application.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	log.Error().Err(err).Msg("dead letter request failed")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func listWaterMetersHandlerFunc(a application.App, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "list-water-meters")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		offset, limit, err := queryPaging(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
+			return
+		}
+
+		meters, err := a.WaterMeters(ctx, offset, limit)
+		if err != nil {
+			log.Error().Err(err).Msg("list water meters")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, meters)
+	})
+}
 
+func getWaterMeterHandlerFunc(a application.App, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "get-water-meter")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		id := chi.URLParam(r, "id")
+
+		meter, err := a.WaterMeter(ctx, id)
+		if errors.Is(err, application.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("get water meter %s", id)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
-		} else {
+		}
+
+		writeJSON(w, http.StatusOK, meter)
+	})
+}
+
+// seriesHandlerFunc returns the observations of an entity of the given type between from and to, by default
+// the last 24 hours, optionally aggregated per hour, day or month in a time zone. Points are written as they
+// are read from the database, so a failure after the first point aborts the response.
+func seriesHandlerFunc(a application.App, log zerolog.Logger, entityType string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "get-series")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		q, err := querySeries(r, entityType)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		flusher, _ := w.(http.Flusher)
+		points := 0
+
+		err = a.Series(ctx, q, func(p application.SeriesPoint) error {
+			b, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+
+			if points == 0 {
+				w.Header().Add("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("["))
+			} else {
+				w.Write([]byte(","))
+			}
+
+			_, err = w.Write(b)
+			points++
+
+			if flusher != nil && points%100 == 0 {
+				flusher.Flush()
+			}
+
+			return err
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("get series of %s", q.Id)
+
+			if points > 0 {
+				panic(http.ErrAbortHandler)
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if points == 0 {
+			w.Header().Add("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("["))
 		}
+
+		w.Write([]byte("]"))
 	})
 }
+
+func querySeries(r *http.Request, entityType string) (application.SeriesQuery, error) {
+	q := application.SeriesQuery{
+		Type:       entityType,
+		Id:         chi.URLParam(r, "id"),
+		To:         time.Now(),
+		Resolution: application.ResolutionRaw,
+	}
+
+	var err error
+
+	if v := r.URL.Query().Get("to"); v != "" {
+		q.To, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, fmt.Errorf("to must be an RFC 3339 timestamp")
+		}
+	}
+
+	q.From = q.To.Add(-24 * time.Hour)
+	if v := r.URL.Query().Get("from"); v != "" {
+		q.From, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, fmt.Errorf("from must be an RFC 3339 timestamp")
+		}
+	}
+
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+
+	if v := r.URL.Query().Get("resolution"); v != "" {
+		q.Resolution = application.Resolution(v)
+	}
+
+	switch q.Resolution {
+	case application.ResolutionRaw, application.ResolutionHour, application.ResolutionDay, application.ResolutionMonth:
+	default:
+		return q, fmt.Errorf("resolution must be one of raw, hour, day or month")
+	}
+
+	timezone := r.URL.Query().Get("timezone")
+	if timezone == "" {
+		timezone = "Europe/Stockholm"
+	}
+
+	q.Location, err = time.LoadLocation(timezone)
+	if err != nil {
+		return q, fmt.Errorf("timezone must be an IANA time zone name")
+	}
+
+	return q, nil
+}
+
+// featuresHandlerFunc returns the latest observations as a GeoJSON FeatureCollection, filtered by type, bbox and
+// distance from a point. A GeoJSON geometry, or a feature, that is posted restricts it to the features that intersect it.
+func featuresHandlerFunc(a application.App, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "get-features")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		q, err := queryFeatures(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			q.Intersects, err = readGeometry(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+
+		features, err := a.Features(ctx, q)
+		if errors.Is(err, application.ErrInvalidQuery) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("get features")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeGeoJSON(w, application.NewFeatureCollection(features))
+	})
+}
+
+// layers maps the names of the resources in the api to the entity types they are stored as
+var layers = map[string]string{
+	"watermeters":       "WaterConsumptionObserved",
+	"weather":           "WeatherObserved",
+	"indoorenvironment": "IndoorEnvironmentObserved",
+}
+
+// geoJSONHandlerFunc returns the latest observation of every entity of a type as a FeatureCollection, for use as
+// a layer in web maps. The type is either an entity type or the name of its resource, such as watermeters. Features
+// are written as they are read from the database, so a failure after the first feature aborts the response.
+func geoJSONHandlerFunc(a application.App, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "get-geojson")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		entityType := chi.URLParam(r, "type")
+		if t, ok := layers[strings.ToLower(entityType)]; ok {
+			entityType = t
+		}
+
+		flusher, _ := w.(http.Flusher)
+		features := 0
+
+		begin := func() {
+			w.Header().Add("Content-Type", "application/geo+json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"type":"FeatureCollection","features":[`))
+		}
+
+		err = a.ExportFeatures(ctx, application.FeatureQuery{Types: []string{entityType}}, func(f application.Feature) error {
+			b, err := json.Marshal(f)
+			if err != nil {
+				return err
+			}
+
+			if features == 0 {
+				begin()
+			} else {
+				w.Write([]byte(","))
+			}
+
+			_, err = w.Write(b)
+			features++
+
+			if flusher != nil && features%100 == 0 {
+				flusher.Flush()
+			}
+
+			return err
+		})
+		if errors.Is(err, application.ErrInvalidQuery) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("get geojson for %s", entityType)
+
+			if features > 0 {
+				panic(http.ErrAbortHandler)
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if features == 0 {
+			begin()
+		}
+
+		w.Write([]byte("]}"))
+	})
+}
+
+func queryFeatures(r *http.Request) (application.FeatureQuery, error) {
+	q := application.FeatureQuery{}
+
+	var err error
+
+	q.Offset, q.Limit, err = queryPaging(r)
+	if err != nil {
+		return q, err
+	}
+
+	if v := r.URL.Query().Get("type"); v != "" {
+		q.Types = strings.Split(v, ",")
+	}
+
+	if v := r.URL.Query().Get("bbox"); v != "" {
+		q.BBox, err = queryFloats(v)
+		if err != nil {
+			return q, fmt.Errorf("bbox must be a comma separated list of numbers")
+		}
+	}
+
+	if v := r.URL.Query().Get("near"); v != "" {
+		q.Near, err = queryFloats(v)
+		if err != nil {
+			return q, fmt.Errorf("near must be a comma separated longitude and latitude")
+		}
+
+		q.Distance, err = strconv.ParseFloat(r.URL.Query().Get("distance"), 64)
+		if err != nil {
+			return q, fmt.Errorf("distance in metres is required along with near")
+		}
+	}
+
+	return q, nil
+}
+
+func queryFloats(v string) ([]float64, error) {
+	values := []float64{}
+
+	for _, s := range strings.Split(v, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, f)
+	}
+
+	return values, nil
+}
+
+// readGeometry returns the GeoJSON geometry in the body of a request, which may also be a feature with a geometry
+func readGeometry(r *http.Request) (json.RawMessage, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	feature := struct {
+		Type     string          `json:"type"`
+		Geometry json.RawMessage `json:"geometry"`
+	}{}
+
+	err = json.Unmarshal(body, &feature)
+	if err != nil {
+		return nil, fmt.Errorf("the body must be a GeoJSON geometry or feature")
+	}
+
+	if feature.Type == "Feature" {
+		return feature.Geometry, nil
+	}
+
+	return body, nil
+}
+
+func writeGeoJSON(w http.ResponseWriter, v any) {
+	b, _ := json.Marshal(v)
+
+	w.Header().Add("Content-Type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// queryPaging returns the offset and limit of a request that returns a page of items, 100 by default and at most 1000
+func queryPaging(r *http.Request) (int, int, error) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	limit, err := queryInt(r, "limit", 100)
+	if err != nil || limit < 1 || limit > 1000 {
+		return 0, 0, fmt.Errorf("limit must be a number between 1 and 1000")
+	}
+
+	return offset, limit, nil
+}
+
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number", name)
+	}
+
+	return i, nil
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	b, _ := json.Marshal(v)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(b)
+}
@@ -4,6 +4,7 @@
 package api
 
 import (
+	"context"
 	"sync"
 )
 
@@ -17,6 +18,9 @@ var _ API = &APIMock{}
 //
 //		// make and configure a mocked API
 //		mockedAPI := &APIMock{
+//			ShutdownFunc: func(ctx context.Context) error {
+//				panic("mock out the Shutdown method")
+//			},
 //			StartFunc: func(port string) error {
 //				panic("mock out the Start method")
 //			},
@@ -27,18 +31,59 @@ var _ API = &APIMock{}
 //
 //	}
 type APIMock struct {
+	// ShutdownFunc mocks the Shutdown method.
+	ShutdownFunc func(ctx context.Context) error
+
 	// StartFunc mocks the Start method.
 	StartFunc func(port string) error
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// Shutdown holds details about calls to the Shutdown method.
+		Shutdown []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+		}
 		// Start holds details about calls to the Start method.
 		Start []struct {
 			// Port is the port argument value.
 			Port string
 		}
 	}
-	lockStart sync.RWMutex
+	lockShutdown sync.RWMutex
+	lockStart    sync.RWMutex
+}
+
+// Shutdown calls ShutdownFunc.
+func (mock *APIMock) Shutdown(ctx context.Context) error {
+	if mock.ShutdownFunc == nil {
+		panic("APIMock.ShutdownFunc: method is nil but API.Shutdown was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+	}{
+		Ctx: ctx,
+	}
+	mock.lockShutdown.Lock()
+	mock.calls.Shutdown = append(mock.calls.Shutdown, callInfo)
+	mock.lockShutdown.Unlock()
+	return mock.ShutdownFunc(ctx)
+}
+
+// ShutdownCalls gets all the calls that were made to Shutdown.
+// Check the length with:
+//
+//	len(mockedAPI.ShutdownCalls())
+func (mock *APIMock) ShutdownCalls() []struct {
+	Ctx context.Context
+} {
+	var calls []struct {
+		Ctx context.Context
+	}
+	mock.lockShutdown.RLock()
+	calls = mock.calls.Shutdown
+	mock.lockShutdown.RUnlock()
+	return calls
 }
 
 // Start calls StartFunc.
@@ -3,9 +3,14 @@ package api
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/diwise/integration-cip-gbg-watermeter/internal/pkg/application"
 	"github.com/go-chi/chi/v5"
@@ -23,6 +28,697 @@ func TestThatNotificationIsUnmarshaledCorrect(t *testing.T) {
 	resp, err := http.DefaultClient.Do(req)
 	is.NoErr(err) // http request failed
 	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+}
+
+func TestThatPartialFailureReturnsMultiStatus(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	api.app.(*application.AppMock).NotificationReceivedFunc = func(ctx context.Context, n application.Notification) (application.NotificationResult, error) {
+		return application.NotificationResult{
+			Stored: 1,
+			Failed: 1,
+			Entities: []application.EntityResult{
+				{Index: 0, Status: application.EntityStored},
+				{Index: 1, Status: application.EntityFailed, Error: "connection refused"},
+			},
+		}, errors.New("connection refused")
+	}
+
+	req, _ := http.NewRequest("POST", ts.URL+"/v2/notify", bytes.NewBuffer([]byte(waterConsumptionObserved_notification)))
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusMultiStatus)
+
+	result := application.NotificationResult{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&result))
+	is.Equal(result.Stored, 1)
+	is.Equal(result.Failed, 1)
+}
+
+func TestThatAFullQueueReturnsServiceUnavailable(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	api.app.(*application.AppMock).NotificationReceivedFunc = func(ctx context.Context, n application.Notification) (application.NotificationResult, error) {
+		return application.NotificationResult{Failed: 1}, &application.QueueFullError{RetryAfter: 1500 * time.Millisecond}
+	}
+
+	req, _ := http.NewRequest("POST", ts.URL+"/v2/notify", bytes.NewBuffer([]byte(waterConsumptionObserved_notification)))
+	resp, err := http.DefaultClient.Do(req)
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusServiceUnavailable)
+	is.Equal(resp.Header.Get("Retry-After"), "2")
+}
+
+func TestNotificationStatusCode(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(notificationStatusCode(application.NotificationResult{Stored: 2, Duplicates: 1}), http.StatusOK)
+	is.Equal(notificationStatusCode(application.NotificationResult{Unsupported: 1}), http.StatusOK)
+	is.Equal(notificationStatusCode(application.NotificationResult{Accepted: 2, Duplicates: 1}), http.StatusAccepted)
+	is.Equal(notificationStatusCode(application.NotificationResult{Stored: 1, Invalid: 1}), http.StatusMultiStatus)
+	is.Equal(notificationStatusCode(application.NotificationResult{Failed: 2}), http.StatusInternalServerError)
+	is.Equal(notificationStatusCode(application.NotificationResult{Invalid: 1, Failed: 1}), http.StatusInternalServerError)
+	is.Equal(notificationStatusCode(application.NotificationResult{Invalid: 3}), http.StatusBadRequest)
+}
+
+func TestThatDeadLettersCanBeListed(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.DeadLettersFunc = func(ctx context.Context, offset, limit int) ([]application.DeadLetter, error) {
+		return []application.DeadLetter{{Id: 1, EntityId: "urn:ngsi-ld:WaterConsumptionObserved:01", Entity: json.RawMessage(`{}`)}}, nil
+	}
+
+	resp, err := admin(http.MethodGet, ts.URL+"/admin/deadletters?offset=10&limit=5")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(app.DeadLettersCalls()[0].Offset, 10)
+	is.Equal(app.DeadLettersCalls()[0].Limit, 5)
+
+	deadLetters := []application.DeadLetter{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&deadLetters))
+	is.Equal(len(deadLetters), 1)
+	is.Equal(deadLetters[0].Id, int64(1))
+}
+
+func TestThatAnInvalidLimitIsRejected(t *testing.T) {
+	is, ts, _, _ := setupTest(t)
+	defer ts.Close()
+
+	resp, err := admin(http.MethodGet, ts.URL+"/admin/deadletters?limit=0")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusBadRequest)
+}
+
+func TestThatAMissingDeadLetterReturnsNotFound(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	api.app.(*application.AppMock).DeadLetterFunc = func(ctx context.Context, id int64) (application.DeadLetter, error) {
+		return application.DeadLetter{}, application.ErrNotFound
+	}
+
+	resp, err := admin(http.MethodGet, ts.URL+"/admin/deadletters/42")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func TestThatADeadLetterCanBeReprocessed(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.ReprocessDeadLetterFunc = func(ctx context.Context, id int64) (application.EntityResult, error) {
+		return application.EntityResult{Id: "urn:ngsi-ld:WaterConsumptionObserved:01", Status: application.EntityStored}, nil
+	}
+
+	resp, err := admin(http.MethodPost, ts.URL+"/admin/deadletters/42/reprocess")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(app.ReprocessDeadLetterCalls()[0].ID, int64(42))
+}
+
+func TestThatADeadLetterThatIsStillInvalidReturnsUnprocessableEntity(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	api.app.(*application.AppMock).ReprocessDeadLetterFunc = func(ctx context.Context, id int64) (application.EntityResult, error) {
+		return application.EntityResult{Status: application.EntityInvalid, Error: "invalid entity"}, nil
+	}
+
+	resp, err := admin(http.MethodPost, ts.URL+"/admin/deadletters/42/reprocess")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusUnprocessableEntity)
+}
+
+func TestThatADeadLetterCanBeDiscarded(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.DiscardDeadLetterFunc = func(ctx context.Context, id int64) error {
+		return nil
+	}
+
+	resp, err := admin(http.MethodDelete, ts.URL+"/admin/deadletters/42")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusNoContent)
+	is.Equal(len(app.DiscardDeadLetterCalls()), 1)
+}
+
+func TestThatTheAdminEndpointsRequireTheToken(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	api.app.(*application.AppMock).DeadLettersFunc = func(ctx context.Context, offset, limit int) ([]application.DeadLetter, error) {
+		return []application.DeadLetter{}, nil
+	}
+
+	for _, authorization := range []string{"", "secret", "Bearer not-the-token", "Basic c2VjcmV0"} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/admin/deadletters", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		is.NoErr(err) // http request failed
+		resp.Body.Close()
+
+		is.Equal(resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	is.Equal(len(api.app.(*application.AppMock).DeadLettersCalls()), 0)
+}
+
+func TestThatTheAdminEndpointsAreDisabledWithoutAToken(t *testing.T) {
+	is := is.New(t)
+	r := chi.NewRouter()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	newApi(zerolog.Nop(), r, &application.AppMock{}, Config{})
+
+	resp, err := admin(http.MethodGet, ts.URL+"/admin/deadletters")
+	is.NoErr(err) // http request failed
+	resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func TestThatTheAdminEndpointsAreNotSharedWithOtherOrigins(t *testing.T) {
+	is := is.New(t)
+	r := chi.NewRouter()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	newApi(zerolog.Nop(), r, &application.AppMock{
+		DeadLettersFunc: func(ctx context.Context, offset, limit int) ([]application.DeadLetter, error) {
+			return []application.DeadLetter{}, nil
+		},
+		WaterMetersFunc: func(ctx context.Context, offset, limit int) ([]application.WaterMeter, error) {
+			return []application.WaterMeter{}, nil
+		},
+	}, Config{AdminToken: adminToken})
+
+	for path, shared := range map[string]bool{"/api/v0/watermeters": true, "/admin/deadletters": false} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		is.NoErr(err) // http request failed
+		resp.Body.Close()
+
+		is.Equal(resp.StatusCode, http.StatusOK)
+		is.Equal(resp.Header.Get("Access-Control-Allow-Origin") != "", shared)
+	}
+}
+
+func TestThatWaterMetersCanBeListed(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	leak := true
+	app := api.app.(*application.AppMock)
+	app.WaterMetersFunc = func(ctx context.Context, offset, limit int) ([]application.WaterMeter, error) {
+		return []application.WaterMeter{{
+			Id:       "urn:ngsi-ld:WaterConsumptionObserved:01",
+			Location: json.RawMessage(`{"type":"Point","coordinates":[11.9,57.7]}`),
+			Alarms:   application.WaterMeterAlarms{AlarmStopsLeaks: &leak},
+		}}, nil
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v0/watermeters?offset=100")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(app.WaterMetersCalls()[0].Offset, 100)
+	is.Equal(app.WaterMetersCalls()[0].Limit, 100)
+
+	meters := []map[string]any{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&meters))
+	is.Equal(len(meters), 1)
+	is.Equal(meters[0]["location"].(map[string]any)["type"], "Point")
+	is.Equal(meters[0]["alarms"].(map[string]any)["alarmStopsLeaks"], true)
+}
+
+func TestThatAWaterMeterCanBeRetrieved(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.WaterMeterFunc = func(ctx context.Context, id string) (application.WaterMeter, error) {
+		if id != "urn:ngsi-ld:WaterConsumptionObserved:01" {
+			return application.WaterMeter{}, application.ErrNotFound
+		}
+		return application.WaterMeter{Id: id}, nil
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v0/watermeters/urn:ngsi-ld:WaterConsumptionObserved:01")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	resp, err = http.Get(ts.URL + "/api/v0/watermeters/urn:ngsi-ld:WaterConsumptionObserved:02")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func TestThatASeriesIsStreamed(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.SeriesFunc = func(ctx context.Context, q application.SeriesQuery, fn func(application.SeriesPoint) error) error {
+		for i := 0; i < 3; i++ {
+			v := float64(i)
+			fn(application.SeriesPoint{Time: q.From.Add(time.Duration(i) * 24 * time.Hour).In(q.Location), Readings: 24, Values: map[string]*float64{"consumptionDelta": &v}})
+		}
+		return nil
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v0/watermeters/urn:ngsi-ld:WaterConsumptionObserved:01/observations?from=2023-09-30T22:00:00Z&to=2023-10-03T22:00:00Z&resolution=day")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	q := app.SeriesCalls()[0].Q
+	is.Equal(q.Type, "WaterConsumptionObserved")
+	is.Equal(q.Id, "urn:ngsi-ld:WaterConsumptionObserved:01")
+	is.Equal(q.Resolution, application.ResolutionDay)
+	is.Equal(q.Location.String(), "Europe/Stockholm")
+
+	points := []application.SeriesPoint{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&points))
+	is.Equal(len(points), 3)
+	is.Equal(points[1].Time.Format(time.RFC3339), "2023-10-02T00:00:00+02:00")
+	is.Equal(*points[2].Values["consumptionDelta"], 2.0)
+}
+
+func TestThatAnEmptySeriesIsAnEmptyArray(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	api.app.(*application.AppMock).SeriesFunc = func(ctx context.Context, q application.SeriesQuery, fn func(application.SeriesPoint) error) error {
+		return nil
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v0/weather/urn:ngsi-ld:WeatherObserved:01/observations")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(string(b), "[]")
+}
+
+func TestThatInvalidSeriesQueriesAreRejected(t *testing.T) {
+	is, ts, _, _ := setupTest(t)
+	defer ts.Close()
+
+	for _, query := range []string{
+		"resolution=week",
+		"from=yesterday",
+		"from=2023-10-02T00:00:00Z&to=2023-10-01T00:00:00Z",
+		"timezone=Europe/Gothenburg",
+	} {
+		resp, err := http.Get(ts.URL + "/api/v0/indoorenvironment/urn:ngsi-ld:IndoorEnvironmentObserved:01/observations?" + query)
+		is.NoErr(err) // http request failed
+		resp.Body.Close()
+
+		is.Equal(resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestThatFeaturesCanBeFilteredByBoundingBoxAndDistance(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.FeaturesFunc = func(ctx context.Context, q application.FeatureQuery) ([]application.Feature, error) {
+		return []application.Feature{{
+			Type:       "Feature",
+			Id:         "urn:ngsi-ld:WaterConsumptionObserved:01",
+			Geometry:   json.RawMessage(`{"type":"Point","coordinates":[11.9,57.7]}`),
+			Properties: json.RawMessage(`{"type":"WaterConsumptionObserved","waterConsumption":191051}`),
+		}}, nil
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v0/features?type=WaterConsumptionObserved&bbox=11.8,57.6,12.0,57.8&near=11.9,57.7&distance=500")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(resp.Header.Get("Content-Type"), "application/geo+json")
+
+	q := app.FeaturesCalls()[0].Q
+	is.Equal(q.Types, []string{"WaterConsumptionObserved"})
+	is.Equal(q.BBox, []float64{11.8, 57.6, 12.0, 57.8})
+	is.Equal(q.Near, []float64{11.9, 57.7})
+	is.Equal(q.Distance, 500.0)
+
+	fc := map[string]any{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&fc))
+	is.Equal(fc["type"], "FeatureCollection")
+	is.Equal(len(fc["features"].([]any)), 1)
+}
+
+func TestThatFeaturesCanBeFilteredByAPostedPolygon(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.FeaturesFunc = func(ctx context.Context, q application.FeatureQuery) ([]application.Feature, error) {
+		return []application.Feature{}, nil
+	}
+
+	polygon := `{"type":"Polygon","coordinates":[[[11.9,57.7],[12.0,57.7],[12.0,57.8],[11.9,57.7]]]}`
+
+	resp, err := http.Post(ts.URL+"/api/v0/features/intersects", "application/geo+json", bytes.NewBufferString(`{"type":"Feature","properties":{"name":"district"},"geometry":`+polygon+`}`))
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(string(app.FeaturesCalls()[0].Q.Intersects), polygon)
+}
+
+func TestThatAnInvalidFeatureQueryIsRejected(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	api.app.(*application.AppMock).FeaturesFunc = func(ctx context.Context, q application.FeatureQuery) ([]application.Feature, error) {
+		return nil, fmt.Errorf("%w: unknown type %s", application.ErrInvalidQuery, q.Types[0])
+	}
+
+	for _, query := range []string{"bbox=11.8,north", "near=11.9,57.7", "type=Device"} {
+		resp, err := http.Get(ts.URL + "/api/v0/features?" + query)
+		is.NoErr(err) // http request failed
+		resp.Body.Close()
+
+		is.Equal(resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestThatALayerIsExportedAsGeoJSON(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.ExportFeaturesFunc = func(ctx context.Context, q application.FeatureQuery, fn func(application.Feature) error) error {
+		if q.Types[0] != "WaterConsumptionObserved" && q.Types[0] != "weatherobserved" {
+			return application.ErrInvalidQuery
+		}
+		return nil
+	}
+
+	for path, statusCode := range map[string]int{"watermeters": http.StatusOK, "weatherobserved": http.StatusOK, "devices": http.StatusNotFound} {
+		resp, err := http.Get(ts.URL + "/api/v0/geojson/" + path)
+		is.NoErr(err) // http request failed
+		resp.Body.Close()
+
+		is.Equal(resp.StatusCode, statusCode)
+	}
+
+	is.Equal(app.ExportFeaturesCalls()[0].Q.Limit, 0) // the whole layer is returned
+}
+
+func TestThatALayerIsStreamedAsAFeatureCollection(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.ExportFeaturesFunc = func(ctx context.Context, q application.FeatureQuery, fn func(application.Feature) error) error {
+		for _, id := range []string{"urn:ngsi-ld:WaterConsumptionObserved:01", "urn:ngsi-ld:WaterConsumptionObserved:02"} {
+			err := fn(application.Feature{Type: "Feature", Id: id, Geometry: json.RawMessage(`{"type":"Point","coordinates":[11.9,57.7]}`), Properties: json.RawMessage(`{}`)})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v0/geojson/watermeters")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(resp.Header.Get("Content-Type"), "application/geo+json")
+
+	fc := application.FeatureCollection{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&fc))
+	is.Equal(fc.Type, "FeatureCollection")
+	is.Equal(len(fc.Features), 2)
+	is.Equal(fc.Features[1].Id, "urn:ngsi-ld:WaterConsumptionObserved:02")
+}
+
+func TestThatAFailedExportIsAborted(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.ExportFeaturesFunc = func(ctx context.Context, q application.FeatureQuery, fn func(application.Feature) error) error {
+		err := fn(application.Feature{Type: "Feature", Id: "urn:ngsi-ld:WaterConsumptionObserved:01", Properties: json.RawMessage(`{}`)})
+		if err != nil {
+			return err
+		}
+		return errors.New("connection lost")
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v0/geojson/watermeters")
+	if err == nil {
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+	}
+
+	is.True(err != nil) // the response should be cut off instead of ending as a valid collection
+}
+
+func TestThatTheOGCLandingPageLinksToTheCollections(t *testing.T) {
+	is, ts, _, _ := setupTest(t)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/ogc")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	page := struct {
+		Links []link `json:"links"`
+	}{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&page))
+
+	rels := map[string]string{}
+	for _, l := range page.Links {
+		rels[l.Rel] = l.Href
+	}
+	is.Equal(rels["data"], ts.URL+"/ogc/collections")
+	is.Equal(rels["conformance"], ts.URL+"/ogc/conformance")
+
+	for _, path := range []string{"/ogc/api", "/ogc/conformance", "/ogc/collections", "/ogc/collections/weather"} {
+		resp, err := http.Get(ts.URL + path)
+		is.NoErr(err) // http request failed
+		resp.Body.Close()
+
+		is.Equal(resp.StatusCode, http.StatusOK) // every linked resource exists
+	}
+
+	resp, err = http.Get(ts.URL + "/ogc/collections/devices")
+	is.NoErr(err) // http request failed
+	resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func TestThatOGCLinksDoNotTrustForwardedHeaders(t *testing.T) {
+	is := is.New(t)
+
+	links := func(cfg Config) map[string]string {
+		req := httptest.NewRequest(http.MethodGet, "http://watermeters.local/ogc", nil)
+		req.Header.Set("X-Forwarded-Proto", "gopher")
+		req.Header.Set("X-Forwarded-Host", "attacker.example")
+
+		w := httptest.NewRecorder()
+		ogcLandingPageHandlerFunc(cfg).ServeHTTP(w, req)
+
+		page := struct {
+			Links []link `json:"links"`
+		}{}
+		is.NoErr(json.NewDecoder(w.Body).Decode(&page))
+
+		rels := map[string]string{}
+		for _, l := range page.Links {
+			rels[l.Rel] = l.Href
+		}
+		return rels
+	}
+
+	is.Equal(links(Config{})["self"], "http://watermeters.local/ogc")
+	is.Equal(links(Config{BaseURL: "https://example.org/watermeter"})["data"], "https://example.org/watermeter/ogc/collections")
+}
+
+func TestThatTheBaseURLIsValidated(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv("API_BASE_URL", "https://example.org/watermeter/")
+	cfg, err := LoadConfig(zerolog.Nop())
+	is.NoErr(err)
+	is.Equal(cfg.BaseURL, "https://example.org/watermeter")
+
+	for _, u := range []string{"example.org", "ftp://example.org", "https://example.org/?a=b"} {
+		t.Setenv("API_BASE_URL", u)
+		_, err = LoadConfig(zerolog.Nop())
+		is.True(err != nil)
+	}
+}
+
+func TestThatOGCItemsArePaged(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.FeaturesFunc = func(ctx context.Context, q application.FeatureQuery) ([]application.Feature, error) {
+		return make([]application.Feature, q.Limit), nil
+	}
+
+	resp, err := http.Get(ts.URL + "/ogc/collections/watermeters/items?limit=2&bbox=11.9,57.6,0,12.1,57.8,100&datetime=2023-10-01T00:00:00Z/..")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(resp.Header.Get("Content-Type"), "application/geo+json")
+
+	fc := featureCollection{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&fc))
+	is.Equal(fc.NumberReturned, 2)
+
+	next := ""
+	for _, l := range fc.Links {
+		if l.Rel == "next" {
+			next = l.Href
+		}
+	}
+	is.True(next != "") // a full page links to the next
+
+	q := app.FeaturesCalls()[0].Q
+	is.Equal(q.Types, []string{"WaterConsumptionObserved"})
+	is.Equal(q.BBox, []float64{11.9, 57.6, 12.1, 57.8}) // the elevation is dropped
+	is.Equal(q.ObservedFrom, time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC))
+	is.True(q.ObservedTo.IsZero())
+
+	resp, err = http.Get(next)
+	is.NoErr(err) // http request failed
+	resp.Body.Close()
+
+	is.Equal(app.FeaturesCalls()[1].Q.Offset, 2)
+}
+
+func TestThatInvalidOGCItemsQueriesAreRejected(t *testing.T) {
+	is, ts, _, _ := setupTest(t)
+	defer ts.Close()
+
+	for _, query := range []string{"type=WeatherObserved", "limit=0", "bbox=1,2,3", "datetime=yesterday", "datetime=../.."} {
+		resp, err := http.Get(ts.URL + "/ogc/collections/weather/items?" + query)
+		is.NoErr(err) // http request failed
+		resp.Body.Close()
+
+		is.Equal(resp.StatusCode, http.StatusBadRequest) // query should be rejected
+	}
+}
+
+func TestThatAnOGCItemCanBeRetrieved(t *testing.T) {
+	is, ts, _, api := setupTest(t)
+	defer ts.Close()
+
+	app := api.app.(*application.AppMock)
+	app.FeaturesFunc = func(ctx context.Context, q application.FeatureQuery) ([]application.Feature, error) {
+		if q.Id != "urn:ngsi-ld:WeatherObserved:01" {
+			return []application.Feature{}, nil
+		}
+		return []application.Feature{{Type: "Feature", Id: q.Id, Geometry: json.RawMessage("null"), Properties: json.RawMessage("{}")}}, nil
+	}
+
+	resp, err := http.Get(ts.URL + "/ogc/collections/weather/items/urn:ngsi-ld:WeatherObserved:01")
+	is.NoErr(err) // http request failed
+	defer resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	f := feature{}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&f))
+	is.Equal(f.Id, "urn:ngsi-ld:WeatherObserved:01")
+	is.Equal(len(f.Links), 2)
+
+	resp, err = http.Get(ts.URL + "/ogc/collections/weather/items/urn:ngsi-ld:WeatherObserved:02")
+	is.NoErr(err) // http request failed
+	resp.Body.Close()
+
+	is.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+const adminToken = "secret"
+
+// admin sends a request that is authorized to use the admin endpoints
+func admin(method, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	return http.DefaultClient.Do(req)
+}
+
+func TestThatTheServerIsShutDown(t *testing.T) {
+	is := is.New(t)
+
+	a := New(zerolog.Nop(), chi.NewRouter(), &application.AppMock{}, Config{})
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- a.Start("0")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the server may not have started listening yet, but is closed either way
+	is.NoErr(a.Shutdown(ctx))
+
+	select {
+	case err := <-stopped:
+		is.True(errors.Is(err, http.ErrServerClosed))
+	case <-ctx.Done():
+		t.Fatal("the server did not stop")
+	}
 }
 
 func setupTest(t *testing.T) (*is.I, *httptest.Server, zerolog.Logger, api) {
@@ -35,10 +731,11 @@ func setupTest(t *testing.T) (*is.I, *httptest.Server, zerolog.Logger, api) {
 		log: log,
 		r:   r,
 		app: &application.AppMock{
-			NotificationReceivedFunc: func(ctx context.Context, n application.Notification) error {
-				return nil
+			NotificationReceivedFunc: func(ctx context.Context, n application.Notification) (application.NotificationResult, error) {
+				return application.NotificationResult{Stored: len(n.Entities)}, nil
 			},
 		},
+		cfg: Config{AdminToken: adminToken},
 	}
 
 	registerHandlers(r, log, api)
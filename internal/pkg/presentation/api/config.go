@@ -0,0 +1,37 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/env"
+	"github.com/rs/zerolog"
+)
+
+// Config holds the settings of the api. The admin endpoints are disabled unless an AdminToken is set.
+type Config struct {
+	// AdminToken is the bearer token that requests to the admin endpoints must be authorized with
+	AdminToken string
+	// BaseURL is the scheme and host, and optionally a path prefix, that clients reach the api at, such as
+	// https://example.org/watermeter. It is required behind a proxy, since links are otherwise made from the address
+	// that requests were sent to.
+	BaseURL string
+}
+
+// LoadConfig reads the api configuration from the environment
+func LoadConfig(log zerolog.Logger) (Config, error) {
+	cfg := Config{
+		AdminToken: env.GetVariableOrDefault(log, "ADMIN_API_TOKEN", ""),
+		BaseURL:    strings.TrimSuffix(env.GetVariableOrDefault(log, "API_BASE_URL", ""), "/"),
+	}
+
+	if cfg.BaseURL != "" {
+		u, err := url.Parse(cfg.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return cfg, fmt.Errorf("invalid API_BASE_URL, expected an absolute http or https url without query or fragment")
+		}
+	}
+
+	return cfg, nil
+}
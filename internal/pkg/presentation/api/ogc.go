@@ -0,0 +1,397 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+
+	"github.com/diwise/integration-cip-gbg-watermeter/internal/pkg/application"
+)
+
+// OGC API - Features, Part 1: Core, with the GeoJSON encoding. Every layer is a collection of the latest
+// observation of each entity. See https://docs.ogc.org/is/17-069r4/17-069r4.html
+
+const ogcPath = "/ogc"
+
+var conformance = []string{
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+}
+
+const crs84 = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+
+type link struct {
+	Href  string `json:"href"`
+	Rel   string `json:"rel"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+type collection struct {
+	Id          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Extent      struct {
+		Spatial struct {
+			BBox [][]float64 `json:"bbox"`
+			CRS  string      `json:"crs"`
+		} `json:"spatial"`
+	} `json:"extent"`
+	ItemType string   `json:"itemType"`
+	CRS      []string `json:"crs"`
+	Links    []link   `json:"links"`
+}
+
+// collections are the layers that are published, in the order they are listed
+var collections = []collection{
+	{Id: "watermeters", Title: "Water meters", Description: "The latest reading of every water meter"},
+	{Id: "weather", Title: "Weather", Description: "The latest observation of every weather station"},
+	{Id: "indoorenvironment", Title: "Indoor environment", Description: "The latest observation of every indoor environment sensor"},
+}
+
+type featureCollection struct {
+	application.FeatureCollection
+	Links          []link `json:"links"`
+	TimeStamp      string `json:"timeStamp"`
+	NumberReturned int    `json:"numberReturned"`
+}
+
+type feature struct {
+	application.Feature
+	Links []link `json:"links"`
+}
+
+const (
+	defaultItems = 100
+	maxItems     = 10000
+)
+
+func registerOGCHandlers(r chi.Router, a api) {
+	r.Route(ogcPath, func(r chi.Router) {
+		r.Get("/", ogcLandingPageHandlerFunc(a.cfg))
+		r.Get("/conformance", ogcConformanceHandlerFunc())
+		r.Get("/api", ogcDefinitionHandlerFunc(a.cfg))
+		r.Get("/collections", ogcCollectionsHandlerFunc(a.cfg))
+		r.Get("/collections/{collectionId}", ogcCollectionHandlerFunc(a.cfg))
+		r.Get("/collections/{collectionId}/items", ogcItemsHandlerFunc(a.app, a.cfg, a.log))
+		r.Get("/collections/{collectionId}/items/{featureId}", ogcItemHandlerFunc(a.app, a.cfg, a.log))
+	})
+}
+
+// baseURL returns the absolute URL of the landing page. It is the configured base URL if there is one, since the
+// address of a proxy in front of the service can not be trusted from the headers of a request, or else the address
+// that the request was sent to.
+func baseURL(cfg Config, r *http.Request) string {
+	if cfg.BaseURL != "" {
+		return cfg.BaseURL + ogcPath
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + ogcPath
+}
+
+func ogcLandingPageHandlerFunc(cfg Config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := baseURL(cfg, r)
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"title":       "integration-cip-gbg-watermeter",
+			"description": "Water meters and environment sensors",
+			"links": []link{
+				{Href: base, Rel: "self", Type: "application/json", Title: "This document"},
+				{Href: base + "/api", Rel: "service-desc", Type: "application/vnd.oai.openapi+json;version=3.0", Title: "The API definition"},
+				{Href: base + "/conformance", Rel: "conformance", Type: "application/json", Title: "Conformance classes"},
+				{Href: base + "/collections", Rel: "data", Type: "application/json", Title: "The collections"},
+			},
+		})
+	})
+}
+
+func ogcConformanceHandlerFunc() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"conformsTo": conformance})
+	})
+}
+
+func ogcDefinitionHandlerFunc(cfg Config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/vnd.oai.openapi+json;version=3.0")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(strings.Replace(openAPIDefinition, "{server}", baseURL(cfg, r), 1)))
+	})
+}
+
+// describe returns a collection with its extent and links. The extent is the whole world since the observations
+// are not known in advance.
+func describe(c collection, base string) collection {
+	c.Extent.Spatial.BBox = [][]float64{{-180, -90, 180, 90}}
+	c.Extent.Spatial.CRS = crs84
+	c.ItemType = "feature"
+	c.CRS = []string{crs84}
+	c.Links = []link{
+		{Href: base + "/collections/" + c.Id, Rel: "self", Type: "application/json"},
+		{Href: base + "/collections/" + c.Id + "/items", Rel: "items", Type: "application/geo+json"},
+	}
+	return c
+}
+
+func ogcCollectionsHandlerFunc(cfg Config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := baseURL(cfg, r)
+
+		described := make([]collection, 0, len(collections))
+		for _, c := range collections {
+			described = append(described, describe(c, base))
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"links": []link{
+				{Href: base + "/collections", Rel: "self", Type: "application/json"},
+			},
+			"collections": described,
+		})
+	})
+}
+
+func findCollection(id string) (collection, bool) {
+	for _, c := range collections {
+		if c.Id == id {
+			return c, true
+		}
+	}
+	return collection{}, false
+}
+
+func ogcCollectionHandlerFunc(cfg Config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := findCollection(chi.URLParam(r, "collectionId"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, describe(c, baseURL(cfg, r)))
+	})
+}
+
+func ogcItemsHandlerFunc(a application.App, cfg Config, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "get-ogc-items")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		c, ok := findCollection(chi.URLParam(r, "collectionId"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		q, err := queryItems(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		q.Types = []string{layers[c.Id]}
+
+		features, err := a.Features(ctx, q)
+		if errors.Is(err, application.ErrInvalidQuery) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("get items in %s", c.Id)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		items := baseURL(cfg, r) + "/collections/" + c.Id + "/items"
+
+		fc := featureCollection{
+			FeatureCollection: application.NewFeatureCollection(features),
+			Links: []link{
+				{Href: items + "?" + r.URL.RawQuery, Rel: "self", Type: "application/geo+json"},
+				{Href: baseURL(cfg, r) + "/collections/" + c.Id, Rel: "collection", Type: "application/json"},
+			},
+			TimeStamp:      time.Now().UTC().Format(time.RFC3339),
+			NumberReturned: len(features),
+		}
+
+		if len(features) == q.Limit {
+			next := r.URL.Query()
+			next.Set("offset", strconv.Itoa(q.Offset+q.Limit))
+			next.Set("limit", strconv.Itoa(q.Limit))
+			fc.Links = append(fc.Links, link{Href: items + "?" + next.Encode(), Rel: "next", Type: "application/geo+json"})
+		}
+
+		writeGeoJSON(w, fc)
+	})
+}
+
+func ogcItemHandlerFunc(a application.App, cfg Config, log zerolog.Logger) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		ctx, span := tracer.Start(r.Context(), "get-ogc-item")
+		defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+		_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+		c, ok := findCollection(chi.URLParam(r, "collectionId"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		id := chi.URLParam(r, "featureId")
+
+		features, err := a.Features(ctx, application.FeatureQuery{Types: []string{layers[c.Id]}, Id: id, Limit: 1})
+		if err != nil {
+			log.Error().Err(err).Msgf("get item %s in %s", id, c.Id)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if len(features) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		base := baseURL(cfg, r) + "/collections/" + c.Id
+
+		writeGeoJSON(w, feature{
+			Feature: features[0],
+			Links: []link{
+				{Href: base + "/items/" + url.PathEscape(id), Rel: "self", Type: "application/geo+json"},
+				{Href: base, Rel: "collection", Type: "application/json"},
+			},
+		})
+	})
+}
+
+// queryItems reads the parameters of an items request. Unknown parameters are rejected, a limit above the
+// maximum is lowered to it and the elevation in a bbox with six numbers is ignored.
+func queryItems(r *http.Request) (application.FeatureQuery, error) {
+	q := application.FeatureQuery{Limit: defaultItems}
+
+	var err error
+
+	for name := range r.URL.Query() {
+		switch name {
+		case "bbox", "datetime", "limit", "offset":
+		default:
+			return q, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+
+	q.Limit, err = queryInt(r, "limit", defaultItems)
+	if err != nil || q.Limit < 1 {
+		return q, fmt.Errorf("limit must be a positive number")
+	}
+	q.Limit = min(q.Limit, maxItems)
+
+	q.Offset, err = queryInt(r, "offset", 0)
+	if err != nil {
+		return q, err
+	}
+
+	if v := r.URL.Query().Get("bbox"); v != "" {
+		q.BBox, err = queryFloats(v)
+		if err != nil || (len(q.BBox) != 4 && len(q.BBox) != 6) {
+			return q, fmt.Errorf("bbox must be four or six comma separated numbers")
+		}
+		if len(q.BBox) == 6 {
+			q.BBox = []float64{q.BBox[0], q.BBox[1], q.BBox[3], q.BBox[4]}
+		}
+	}
+
+	if v := r.URL.Query().Get("datetime"); v != "" {
+		q.ObservedFrom, q.ObservedTo, err = parseDatetime(v)
+		if err != nil {
+			return q, err
+		}
+	}
+
+	return q, nil
+}
+
+// parseDatetime parses an instant or an interval, such as 2023-10-01T00:00:00Z/.. for everything after a
+// point in time. An instant is returned as both the start and the end.
+func parseDatetime(v string) (time.Time, time.Time, error) {
+	bound := func(s string) (time.Time, error) {
+		if s == "" || s == ".." {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, s)
+	}
+
+	start, end, interval := strings.Cut(v, "/")
+
+	from, err := bound(start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("datetime must be an RFC 3339 timestamp or an interval")
+	}
+
+	if !interval {
+		if from.IsZero() {
+			return time.Time{}, time.Time{}, fmt.Errorf("datetime must be an RFC 3339 timestamp or an interval")
+		}
+		return from, from, nil
+	}
+
+	to, err := bound(end)
+	if err != nil || (from.IsZero() && to.IsZero()) {
+		return time.Time{}, time.Time{}, fmt.Errorf("datetime must be an RFC 3339 timestamp or an interval")
+	}
+
+	return from, to, nil
+}
+
+// openAPIDefinition describes the OGC API. {server} is replaced with the URL of the landing page.
+const openAPIDefinition = `{
+  "openapi": "3.0.3",
+  "info": {"title": "integration-cip-gbg-watermeter", "version": "1.0.0", "description": "OGC API - Features for water meters and environment sensors"},
+  "servers": [{"url": "{server}"}],
+  "paths": {
+    "/": {"get": {"summary": "Landing page", "responses": {"200": {"description": "Links to the API definition, conformance and collections"}}}},
+    "/conformance": {"get": {"summary": "Conformance classes", "responses": {"200": {"description": "The conformance classes that are implemented"}}}},
+    "/collections": {"get": {"summary": "Collections", "responses": {"200": {"description": "The collections of features"}}}},
+    "/collections/{collectionId}": {"get": {"summary": "A collection",
+      "parameters": [{"$ref": "#/components/parameters/collectionId"}],
+      "responses": {"200": {"description": "The collection"}, "404": {"description": "The collection does not exist"}}}},
+    "/collections/{collectionId}/items": {"get": {"summary": "The latest observation of each entity in a collection",
+      "parameters": [
+        {"$ref": "#/components/parameters/collectionId"},
+        {"name": "bbox", "in": "query", "style": "form", "explode": false, "schema": {"type": "array", "minItems": 4, "maxItems": 6, "items": {"type": "number"}}},
+        {"name": "datetime", "in": "query", "schema": {"type": "string"}},
+        {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 10000, "default": 100}},
+        {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}}
+      ],
+      "responses": {"200": {"description": "A GeoJSON FeatureCollection"}, "400": {"description": "Invalid parameters"}, "404": {"description": "The collection does not exist"}}}},
+    "/collections/{collectionId}/items/{featureId}": {"get": {"summary": "The latest observation of an entity",
+      "parameters": [{"$ref": "#/components/parameters/collectionId"}, {"name": "featureId", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "responses": {"200": {"description": "A GeoJSON Feature"}, "404": {"description": "The feature does not exist"}}}}
+  },
+  "components": {
+    "parameters": {
+      "collectionId": {"name": "collectionId", "in": "path", "required": true, "schema": {"type": "string", "enum": ["watermeters", "weather", "indoorenvironment"]}}
+    }
+  }
+}`